@@ -4,19 +4,20 @@ cd $GOPATH/src/
 
 # remove codes
 rm -rf coredns
-rm -rf nacos-coredns-plugin
+rm -rf github.com/nacos-group/nacos-coredns-plugin
 
-# clone current codes
+# clone current codes, the plugin at its import path for its subpackages
 git clone https://github.com/coredns/coredns.git
-git clone https://github.com/nacos-group/nacos-coredns-plugin.git
+git clone https://github.com/nacos-group/nacos-coredns-plugin.git github.com/nacos-group/nacos-coredns-plugin
 
 # cd coredns directory
 cd $GOPATH/src/coredns
 git checkout -b v1.2.6 v1.2.6
 go get github.com/cihub/seelog
+go get gopkg.in/yaml.v2
 
 # copy nacos plugin to coredns
-cp -r ../nacos-coredns-plugin/nacos plugin/
+cp -r ../github.com/nacos-group/nacos-coredns-plugin/nacos plugin/
 
 # insert nacos into plugin
 sed -i '/coredns\/core\/dnsserver/a\\t_ "coredns/plugin/nacos"' core/coredns.go
@@ -31,3 +32,6 @@ mv /tmp/Makefile .
 
 # build
 make
+
+# build nacosctl, it does not need CoreDNS
+go build -o nacosctl github.com/nacos-group/nacos-coredns-plugin/cmd/nacosctl
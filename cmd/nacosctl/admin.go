@@ -0,0 +1,94 @@
+/*
+ * Copyright 1999-2018 Alibaba Group Holding Ltd.
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *      http://www.apache.org/licenses/LICENSE-2.0
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package main
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"os"
+)
+
+var adminClient = http.Client{Timeout: queryTimeout}
+
+// adminRequest builds the request of an endpoint of the admin API.
+func adminRequest(addr, endpoint string, args []string) (*http.Request, error) {
+	method, query := "GET", url.Values{}
+	switch endpoint {
+	case "services", "doms", "servers", "upstream-cache", "ready":
+		if len(args) > 0 {
+			return nil, fmt.Errorf("%s takes no arguments", endpoint)
+		}
+	case "refresh":
+		if len(args) == 0 || len(args) > 2 {
+			return nil, errors.New("refresh needs a service and an optional client IP")
+		}
+		method = "POST"
+		query.Set("dom", args[0])
+		if len(args) == 2 {
+			query.Set("clientIP", args[1])
+		}
+	case "purge":
+		if len(args) > 1 {
+			return nil, errors.New("purge takes an optional cache key")
+		}
+		method = "POST"
+		if len(args) == 1 {
+			query.Set("key", args[0])
+		}
+	default:
+		return nil, fmt.Errorf("unknown admin endpoint '%s'", endpoint)
+	}
+
+	u := url.URL{Scheme: "http", Host: addr, Path: "/" + endpoint, RawQuery: query.Encode()}
+	return http.NewRequest(method, u.String(), nil)
+}
+
+func admin(args []string, stdout io.Writer) error {
+	flags := newFlags("admin")
+	addr := flags.String("addr", "127.0.0.1:8053", "address of the admin API")
+	token := flags.String("token", os.Getenv("NACOS_ADMIN_TOKEN"), "token of the admin API, $NACOS_ADMIN_TOKEN by default")
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+	if flags.NArg() == 0 {
+		return errors.New("admin needs an endpoint")
+	}
+
+	req, err := adminRequest(*addr, flags.Arg(0), flags.Args()[1:])
+	if err != nil {
+		return err
+	}
+	if *token != "" {
+		req.Header.Set("Authorization", "Bearer "+*token)
+	}
+
+	resp, err := adminClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	b, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return err
+	}
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("%s: %s", resp.Status, b)
+	}
+	return writeIndented(stdout, string(b))
+}
@@ -0,0 +1,292 @@
+/*
+ * Copyright 1999-2018 Alibaba Group Holding Ltd.
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *      http://www.apache.org/licenses/LICENSE-2.0
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"text/tabwriter"
+	"time"
+
+	"github.com/nacos-group/nacos-coredns-plugin/nacos/nacoscache"
+)
+
+// the timeout of a query to nacos
+var queryTimeout = 10 * time.Second
+
+// cacheFile is a snapshot of a service in the cache dir, named by its cache key.
+type cacheFile struct {
+	Key      string
+	Dom      string
+	ClientIP string
+	ModTime  time.Time
+	Content  string
+	Domain   nacoscache.Domain
+	// why Parse rejects the file, the plugin skips it when loading the cache
+	Err error
+}
+
+func readCacheFile(dir, key string) (cacheFile, error) {
+	f := cacheFile{Key: key}
+	f.Dom, f.ClientIP = nacoscache.SplitKey(filepath.Base(key))
+
+	path := filepath.Join(dir, key)
+	info, err := os.Stat(path)
+	if err != nil {
+		return f, err
+	}
+	f.ModTime = info.ModTime()
+
+	b, err := ioutil.ReadFile(path)
+	if err != nil {
+		return f, err
+	}
+	f.Content = string(b)
+	f.Domain, f.Err = nacoscache.Parse(f.Content)
+	return f, nil
+}
+
+// readCacheDir reads every cache file in dir, ordered by key. The files in
+// the dirs of dir are read as well, the plugin caches the doms of a nacos
+// cluster in a dir of the default cache dir, see nacoscache.ClusterDir.
+// Their keys start with the dir, e.g. 10.0.0.1_8848/hello123@@10.0.0.5.
+func readCacheDir(dir string) ([]cacheFile, error) {
+	files, err := readCacheFiles(dir, "")
+	if err != nil {
+		return nil, err
+	}
+	sort.Slice(files, func(i, j int) bool { return files[i].Key < files[j].Key })
+	return files, nil
+}
+
+func readCacheFiles(dir, sub string) ([]cacheFile, error) {
+	infos, err := ioutil.ReadDir(filepath.Join(dir, sub))
+	if err != nil {
+		return nil, err
+	}
+
+	files := make([]cacheFile, 0, len(infos))
+	for _, info := range infos {
+		key := filepath.Join(sub, info.Name())
+		if info.IsDir() {
+			if sub != "" {
+				continue
+			}
+			cluster, err := readCacheFiles(dir, key)
+			if err != nil {
+				return nil, err
+			}
+			files = append(files, cluster...)
+			continue
+		}
+		f, err := readCacheFile(dir, key)
+		if err != nil {
+			return nil, err
+		}
+		files = append(files, f)
+	}
+	return files, nil
+}
+
+func list(args []string, stdout io.Writer) error {
+	flags := newFlags("list")
+	dir := flags.String("dir", defaultCacheDir(), "cache dir of the plugin")
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+
+	files, err := readCacheDir(*dir)
+	if err != nil {
+		return err
+	}
+
+	w := tabwriter.NewWriter(stdout, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(w, "KEY\tSERVICE\tCLIENT IP\tINSTANCES\tVALID\tMODIFIED")
+	for _, f := range files {
+		instances, valid := "-", "-"
+		if f.Err == nil {
+			instances = strconv.Itoa(len(f.Domain.Instances))
+			valid = strconv.Itoa(countValid(f.Domain.Instances))
+		}
+		fmt.Fprintf(w, "%s\t%s\t%s\t%s\t%s\t%s\n", f.Key, f.Dom, f.ClientIP, instances, valid, f.ModTime.Format(time.RFC3339))
+	}
+	return w.Flush()
+}
+
+func countValid(instances []nacoscache.Instance) int {
+	n := 0
+	for _, instance := range instances {
+		if instance.Valid {
+			n++
+		}
+	}
+	return n
+}
+
+func show(args []string, stdout io.Writer) error {
+	flags := newFlags("show")
+	dir := flags.String("dir", defaultCacheDir(), "cache dir of the plugin")
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+	if flags.NArg() != 1 {
+		return errors.New("show needs a cache key")
+	}
+
+	f, err := readCacheFile(*dir, flags.Arg(0))
+	if err != nil {
+		return err
+	}
+	if f.Err != nil {
+		fmt.Fprintln(os.Stderr, "warning: the plugin ignores this file: "+f.Err.Error())
+	}
+	return writeIndented(stdout, f.Content)
+}
+
+// writeIndented pretty prints s if it is JSON and prints it as it is otherwise.
+func writeIndented(w io.Writer, s string) error {
+	var buf bytes.Buffer
+	if err := json.Indent(&buf, []byte(s), "", "  "); err != nil {
+		_, err = fmt.Fprintln(w, s)
+		return err
+	}
+	_, err := fmt.Fprintln(w, buf.String())
+	return err
+}
+
+func validate(args []string, stdout io.Writer) error {
+	flags := newFlags("validate")
+	dir := flags.String("dir", defaultCacheDir(), "cache dir of the plugin")
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+
+	files, err := readCacheDir(*dir)
+	if err != nil {
+		return err
+	}
+
+	invalid := 0
+	for _, f := range files {
+		if f.Err != nil {
+			invalid++
+			fmt.Fprintf(stdout, "%s: %v\n", f.Key, f.Err)
+		}
+	}
+	if invalid > 0 {
+		return fmt.Errorf("%d of %d cache files are invalid", invalid, len(files))
+	}
+	fmt.Fprintf(stdout, "%d cache files are valid\n", len(files))
+	return nil
+}
+
+func query(args []string, stdout io.Writer) error {
+	flags := newFlags("query")
+	server := flags.String("server", "127.0.0.1:8848", "nacos server")
+	clientIP := flags.String("client-ip", "", "client IP the service is queried for")
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+	if flags.NArg() != 1 {
+		return errors.New("query needs a service")
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), queryTimeout)
+	defer cancel()
+	s, err := nacoscache.Fetch(ctx, nil, *server, nil, flags.Arg(0), *clientIP)
+	if err != nil {
+		return err
+	}
+	return writeIndented(stdout, s)
+}
+
+func diff(args []string, stdout io.Writer) error {
+	flags := newFlags("diff")
+	dir := flags.String("dir", defaultCacheDir(), "cache dir of the plugin")
+	server := flags.String("server", "127.0.0.1:8848", "nacos server")
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+
+	files, err := readCacheDir(*dir)
+	if err != nil {
+		return err
+	}
+
+	differ := 0
+	for _, f := range files {
+		ctx, cancel := context.WithTimeout(context.Background(), queryTimeout)
+		s, err := nacoscache.Fetch(ctx, nil, *server, nil, f.Dom, f.ClientIP)
+		cancel()
+		if err != nil {
+			return fmt.Errorf("failed to query %s: %v", f.Key, err)
+		}
+		// an empty instance list is what nacos has, not an error here
+		remote, _ := nacoscache.Parse(s)
+
+		changes := diffInstances(f.Domain.Instances, remote.Instances)
+		if len(changes) == 0 {
+			continue
+		}
+		differ++
+		fmt.Fprintln(stdout, f.Key+":")
+		for _, change := range changes {
+			fmt.Fprintln(stdout, "  "+change)
+		}
+	}
+
+	if differ > 0 {
+		return fmt.Errorf("%d of %d cached services differ from %s", differ, len(files), *server)
+	}
+	fmt.Fprintf(stdout, "%d cached services match %s\n", len(files), *server)
+	return nil
+}
+
+// diffInstances describes how the instances in nacos differ from the
+// cached ones, by ip:port.
+func diffInstances(cached, remote []nacoscache.Instance) []string {
+	addr := func(instance nacoscache.Instance) string {
+		return instance.IP + ":" + strconv.Itoa(instance.Port)
+	}
+	byAddr := make(map[string]nacoscache.Instance)
+	for _, instance := range cached {
+		byAddr[addr(instance)] = instance
+	}
+
+	changes := make([]string, 0)
+	for _, r := range remote {
+		c, ok := byAddr[addr(r)]
+		delete(byAddr, addr(r))
+		switch {
+		case !ok:
+			changes = append(changes, fmt.Sprintf("+ %s weight %v valid %t", addr(r), r.Weight, r.Valid))
+		case c.Weight != r.Weight || c.Valid != r.Valid:
+			changes = append(changes, fmt.Sprintf("~ %s weight %v -> %v valid %t -> %t", addr(r), c.Weight, r.Weight, c.Valid, r.Valid))
+		}
+	}
+	for a, c := range byAddr {
+		changes = append(changes, fmt.Sprintf("- %s weight %v valid %t", a, c.Weight, c.Valid))
+	}
+	sort.Slice(changes, func(i, j int) bool { return changes[i][2:] < changes[j][2:] })
+	return changes
+}
@@ -0,0 +1,158 @@
+/*
+ * Copyright 1999-2018 Alibaba Group Holding Ltd.
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *      http://www.apache.org/licenses/LICENSE-2.0
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package main
+
+import (
+	"bytes"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/nacos-group/nacos-coredns-plugin/nacos/nacoscache"
+)
+
+func writeCacheDir(t *testing.T, files map[string]string) string {
+	dir, err := ioutil.TempDir("", "nacosctl")
+	if err != nil {
+		t.Fatal(err)
+	}
+	for key, content := range files {
+		if err := os.MkdirAll(filepath.Dir(filepath.Join(dir, key)), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := ioutil.WriteFile(filepath.Join(dir, key), []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return dir
+}
+
+func TestValidate(t *testing.T) {
+	dir := writeCacheDir(t, map[string]string{
+		"DEFAULT_GROUP@@hello123@@10.0.0.5": `{"dom":"hello123","hosts":[{"ip":"2.2.2.2","port":80,"valid":true}]}`,
+		"world456@@10.0.0.5":                `{"dom":"world456","hosts":[]}`,
+	})
+	defer os.RemoveAll(dir)
+
+	var out bytes.Buffer
+	if err := validate([]string{"-dir", dir}, &out); err == nil || !strings.Contains(err.Error(), "1 of 2") {
+		t.Fatalf("expected one invalid file, got %v", err)
+	}
+	if !strings.HasPrefix(out.String(), "world456@@10.0.0.5: ") {
+		t.Fatalf("unexpected output %q", out.String())
+	}
+
+	files, err := readCacheDir(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if files[0].Dom != "DEFAULT_GROUP@@hello123" || files[0].ClientIP != "10.0.0.5" || len(files[0].Domain.Instances) != 1 {
+		t.Fatalf("unexpected cache file %+v", files[0])
+	}
+}
+
+func TestReadCacheDir_ClusterDirs(t *testing.T) {
+	dir := writeCacheDir(t, map[string]string{
+		"10.0.0.1_8848/hello123@@10.0.0.5": `{"dom":"hello123","hosts":[{"ip":"2.2.2.2","port":80,"valid":true}]}`,
+		"world456@@10.0.0.5":               `{"dom":"world456","hosts":[{"ip":"5.5.5.5","port":80,"valid":true}]}`,
+	})
+	defer os.RemoveAll(dir)
+
+	files, err := readCacheDir(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(files) != 2 || files[0].Key != "10.0.0.1_8848/hello123@@10.0.0.5" || files[0].Dom != "hello123" || files[0].ClientIP != "10.0.0.5" {
+		t.Fatalf("unexpected cache files %+v", files)
+	}
+
+	var out bytes.Buffer
+	if err := show([]string{"-dir", dir, files[0].Key}, &out); err != nil || !strings.Contains(out.String(), "2.2.2.2") {
+		t.Fatalf("expected show to read the key of a cluster dir, got %q, %v", out.String(), err)
+	}
+}
+
+func TestDiff(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		if req.URL.Path != "/nacos/v1/ns/api/srvIPXT" || req.URL.Query().Get("dom") != "hello123" || req.URL.Query().Get("clientIP") != "10.0.0.5" {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		w.Write([]byte(`{"dom":"hello123","hosts":[{"ip":"2.2.2.2","port":80,"weight":2,"valid":true},{"ip":"3.3.3.3","port":80,"weight":1,"valid":true}]}`))
+	}))
+	defer server.Close()
+
+	dir := writeCacheDir(t, map[string]string{
+		"hello123@@10.0.0.5": `{"dom":"hello123","hosts":[{"ip":"1.1.1.1","port":80,"weight":1,"valid":true},{"ip":"2.2.2.2","port":80,"weight":1,"valid":true}]}`,
+	})
+	defer os.RemoveAll(dir)
+
+	var out bytes.Buffer
+	if err := diff([]string{"-dir", dir, "-server", strings.TrimPrefix(server.URL, "http://")}, &out); err == nil {
+		t.Fatal("expected the cache to differ")
+	}
+	expected := "hello123@@10.0.0.5:\n  - 1.1.1.1:80 weight 1 valid true\n  ~ 2.2.2.2:80 weight 1 -> 2 valid true -> true\n  + 3.3.3.3:80 weight 1 valid true\n"
+	if out.String() != expected {
+		t.Fatalf("expected\n%s\ngot\n%s", expected, out.String())
+	}
+}
+
+func TestDiffInstances(t *testing.T) {
+	instances := []nacoscache.Instance{{IP: "1.1.1.1", Port: 80, Weight: 1, Valid: true}}
+	if changes := diffInstances(instances, instances); len(changes) != 0 {
+		t.Fatalf("expected no changes, got %v", changes)
+	}
+
+	changes := diffInstances(instances, []nacoscache.Instance{{IP: "1.1.1.1", Port: 80, Weight: 1, Valid: false}})
+	if !reflect.DeepEqual(changes, []string{"~ 1.1.1.1:80 weight 1 -> 1 valid true -> false"}) {
+		t.Fatalf("unexpected changes %v", changes)
+	}
+}
+
+func TestAdminRequest(t *testing.T) {
+	tests := []struct {
+		endpoint string
+		args     []string
+		expected string
+	}{
+		{"services", nil, "GET http://127.0.0.1:8053/services"},
+		{"ready", nil, "GET http://127.0.0.1:8053/ready"},
+		{"refresh", []string{"DEFAULT_GROUP@@hello123", "10.0.0.5"}, "POST http://127.0.0.1:8053/refresh?clientIP=10.0.0.5&dom=DEFAULT_GROUP%40%40hello123"},
+		{"purge", nil, "POST http://127.0.0.1:8053/purge"},
+		{"purge", []string{"hello123@@10.0.0.5"}, "POST http://127.0.0.1:8053/purge?key=hello123%40%4010.0.0.5"},
+		{"services", []string{"x"}, ""},
+		{"refresh", nil, ""},
+		{"reload", nil, ""},
+	}
+
+	for i, test := range tests {
+		req, err := adminRequest("127.0.0.1:8053", test.endpoint, test.args)
+		if test.expected == "" {
+			if err == nil {
+				t.Errorf("Test %d: expected an error", i)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("Test %d: %v", i, err)
+		} else if req.Method+" "+req.URL.String() != test.expected {
+			t.Errorf("Test %d: expected %s, got %s %s", i, test.expected, req.Method, req.URL)
+		}
+	}
+}
@@ -0,0 +1,93 @@
+/*
+ * Copyright 1999-2018 Alibaba Group Holding Ltd.
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *      http://www.apache.org/licenses/LICENSE-2.0
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+// Command nacosctl inspects the cache of the nacos plugin and talks to
+// nacos servers and to the admin API of a running agent.
+package main
+
+import (
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"os/user"
+	"path/filepath"
+)
+
+const usage = `usage: nacosctl <command> [flags] [args]
+
+commands:
+  list      [-dir dir]                                  list the cached services
+  show      [-dir dir] <key>                            print a cached service
+  validate  [-dir dir]                                  check every cache file
+  query     [-server host:port] [-client-ip ip] <service>
+                                                        fetch a service from nacos
+  diff      [-dir dir] [-server host:port]              compare the cache with nacos
+  admin     [-addr host:port] [-token token] <endpoint> [args]
+                                                        call the admin API of an agent,
+                                                        endpoint is one of services, doms,
+                                                        servers, upstream-cache, ready,
+                                                        refresh <service> [clientIP]
+                                                        and purge [key]
+
+Services are given as [namespace##][group@@]name, cache keys as listed by list.
+`
+
+type command func(args []string, stdout io.Writer) error
+
+var commands = map[string]command{
+	"list":     list,
+	"show":     show,
+	"validate": validate,
+	"query":    query,
+	"diff":     diff,
+	"admin":    admin,
+}
+
+func main() {
+	flags := flag.NewFlagSet("nacosctl", flag.ExitOnError)
+	flags.Usage = func() { fmt.Fprint(os.Stderr, usage) }
+	flags.Parse(os.Args[1:])
+
+	if flags.NArg() == 0 {
+		flags.Usage()
+		os.Exit(2)
+	}
+	cmd, ok := commands[flags.Arg(0)]
+	if !ok {
+		fmt.Fprintf(os.Stderr, "unknown command '%s'\n\n", flags.Arg(0))
+		flags.Usage()
+		os.Exit(2)
+	}
+
+	if err := cmd(flags.Args()[1:], os.Stdout); err != nil {
+		fmt.Fprintln(os.Stderr, "nacosctl: "+err.Error())
+		os.Exit(1)
+	}
+}
+
+// defaultCacheDir is the cache dir of the plugin if cache_dir is not set.
+func defaultCacheDir() string {
+	home := os.Getenv("HOME")
+	if u, err := user.Current(); err == nil {
+		home = u.HomeDir
+	}
+	return filepath.Join(home, "nacos-go-client-cache")
+}
+
+// newFlags returns the flags of a command, its usage is the usage of nacosctl.
+func newFlags(name string) *flag.FlagSet {
+	flags := flag.NewFlagSet(name, flag.ContinueOnError)
+	flags.Usage = func() { fmt.Fprint(os.Stderr, usage) }
+	return flags
+}
@@ -0,0 +1,265 @@
+/*
+ * Copyright 1999-2018 Alibaba Group Holding Ltd.
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *      http://www.apache.org/licenses/LICENSE-2.0
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package nacos
+
+import (
+	"crypto/subtle"
+	"encoding/json"
+	"net"
+	"net/http"
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/nacos-group/nacos-coredns-plugin/nacos/nacoscache"
+)
+
+// Admin is an HTTP API to inspect and control the caches of a nacos block.
+//
+//	GET  /services        cached doms and their instances
+//	GET  /doms            doms registered in nacos
+//	GET  /servers         nacos servers
+//	GET  /upstream-cache  cached upstream answers
+//	GET  /ready           200 once the block is ready, 503 before, see Nacos.Ready
+//	POST /refresh?dom=    fetches dom from nacos, clientIP is optional
+//	POST /purge?key=      removes a cache key, or all of them without key
+type Admin struct {
+	Addr string
+	// if set, requests need an "Authorization: Bearer <Token>" header
+	Token string
+
+	vs     *Nacos
+	mux    *http.ServeMux
+	shared *adminListener
+}
+
+// adminListeners are the listeners of the admin APIs by address. A server
+// block with several keys sets up a nacos block per key, and on a reload
+// the new instance starts before the old one is shut down, so the APIs on
+// an address share its listener. The API started last answers.
+var adminListeners = struct {
+	sync.Mutex
+	byAddr map[string]*adminListener
+}{byAddr: make(map[string]*adminListener)}
+
+type adminListener struct {
+	listener net.Listener
+	admins   []*Admin
+}
+
+func (l *adminListener) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	adminListeners.Lock()
+	var a *Admin
+	if len(l.admins) > 0 {
+		a = l.admins[len(l.admins)-1]
+	}
+	adminListeners.Unlock()
+
+	if a == nil {
+		http.Error(w, "shutting down", http.StatusServiceUnavailable)
+		return
+	}
+	a.ServeHTTP(w, r)
+}
+
+// NewAdmin returns an admin API for vs, it is not listening until Start is called.
+func NewAdmin(addr, token string, vs *Nacos) *Admin {
+	a := &Admin{Addr: addr, Token: token, vs: vs, mux: http.NewServeMux()}
+	a.mux.HandleFunc("/services", a.method("GET", a.services))
+	a.mux.HandleFunc("/doms", a.method("GET", a.doms))
+	a.mux.HandleFunc("/servers", a.method("GET", a.servers))
+	a.mux.HandleFunc("/upstream-cache", a.method("GET", a.upstreamCache))
+	a.mux.HandleFunc("/ready", a.method("GET", a.ready))
+	a.mux.HandleFunc("/refresh", a.method("POST", a.refresh))
+	a.mux.HandleFunc("/purge", a.method("POST", a.purge))
+	return a
+}
+
+// Start listens on Addr and serves the API in background, it takes over
+// the listener of an API already started on Addr.
+func (a *Admin) Start() error {
+	adminListeners.Lock()
+	defer adminListeners.Unlock()
+
+	l, ok := adminListeners.byAddr[a.Addr]
+	if !ok {
+		listener, err := net.Listen("tcp", a.Addr)
+		if err != nil {
+			return err
+		}
+		l = &adminListener{listener: listener}
+		go http.Serve(listener, l)
+		// a random port can not be shared
+		if _, port, _ := net.SplitHostPort(a.Addr); port != "0" {
+			adminListeners.byAddr[a.Addr] = l
+		}
+	}
+	l.admins = append(l.admins, a)
+	a.shared = l
+
+	a.vs.logger().Info("admin API listening on " + l.listener.Addr().String())
+	return nil
+}
+
+// Stop hands the listener back to the API started before on Addr, the
+// listener is closed once no API is left.
+func (a *Admin) Stop() error {
+	adminListeners.Lock()
+	defer adminListeners.Unlock()
+
+	l := a.shared
+	if l == nil {
+		return nil
+	}
+	a.shared = nil
+
+	for i, other := range l.admins {
+		if other == a {
+			l.admins = append(l.admins[:i], l.admins[i+1:]...)
+			break
+		}
+	}
+	if len(l.admins) > 0 {
+		return nil
+	}
+	if adminListeners.byAddr[a.Addr] == l {
+		delete(adminListeners.byAddr, a.Addr)
+	}
+	return l.listener.Close()
+}
+
+func (a *Admin) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if a.Token != "" {
+		token := strings.TrimPrefix(r.Header.Get("Authorization"), "Bearer ")
+		if subtle.ConstantTimeCompare([]byte(token), []byte(a.Token)) != 1 {
+			http.Error(w, "unauthorized", http.StatusUnauthorized)
+			return
+		}
+	}
+	a.mux.ServeHTTP(w, r)
+}
+
+func (a *Admin) method(method string, handler http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != method {
+			http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+		handler(w, r)
+	}
+}
+
+func (a *Admin) services(w http.ResponseWriter, r *http.Request) {
+	writeJSON(w, a.vs.NacosClientImpl.GetDomainCache())
+}
+
+func (a *Admin) doms(w http.ResponseWriter, r *http.Request) {
+	doms := a.vs.NacosClientImpl.AllDomNames()
+	sort.Strings(doms)
+	writeJSON(w, doms)
+}
+
+func (a *Admin) servers(w http.ResponseWriter, r *http.Request) {
+	client := a.vs.NacosClientImpl
+	manager := client.GetServerManager()
+	writeJSON(w, map[string]interface{}{
+		"servers":         manager.GetServerList(),
+		"lastRefreshTime": manager.lastRefreshTime,
+		"lastContact":     client.LastContact().UnixNano() / 1000000,
+		"synced":          client.Synced(),
+	})
+}
+
+func (a *Admin) upstreamCache(w http.ResponseWriter, r *http.Request) {
+	type entry struct {
+		Answer          []string `json:"answer"`
+		LastUpdateMills int64    `json:"lastUpdateMills"`
+		TTL             uint32   `json:"ttl"`
+	}
+
+	entries := make(map[string]entry)
+	for key, v := range a.vs.DNSCache.Items() {
+		dnsCache := v.(DnsCache)
+		e := entry{LastUpdateMills: dnsCache.LastUpdateMills, TTL: dnsCache.TTL, Answer: []string{}}
+		if dnsCache.Msg != nil {
+			for _, rr := range dnsCache.Msg.Answer {
+				e.Answer = append(e.Answer, rr.String())
+			}
+		}
+		entries[key] = e
+	}
+	writeJSON(w, entries)
+}
+
+// ready reports Nacos.Ready for readiness probes, the ready plugin that asks
+// plugins for it is not part of every CoreDNS version.
+func (a *Admin) ready(w http.ResponseWriter, r *http.Request) {
+	if !a.vs.Ready() {
+		http.Error(w, "not ready", http.StatusServiceUnavailable)
+		return
+	}
+	writeJSON(w, map[string]bool{"ready": true})
+}
+
+func (a *Admin) refresh(w http.ResponseWriter, r *http.Request) {
+	dom := r.URL.Query().Get("dom")
+	if dom == "" {
+		http.Error(w, "dom is required", http.StatusBadRequest)
+		return
+	}
+
+	clientIP := r.URL.Query().Get("clientIP")
+	// both end up in the name of the cache file of the dom
+	if !validRefreshParam(dom) || !validRefreshParam(clientIP) {
+		http.Error(w, "dom and clientIP must not contain path separators or ..", http.StatusBadRequest)
+		return
+	}
+
+	client := a.vs.NacosClientImpl
+	domain, err := client.fetchDom(r.Context(), dom, &client.domainMap, clientIP)
+	switch {
+	case err == nacoscache.ErrNoInstances:
+		http.Error(w, "dom "+dom+" has no instances in nacos", http.StatusNotFound)
+		return
+	case err != nil:
+		http.Error(w, "failed to fetch "+dom+" from nacos: "+err.Error(), http.StatusBadGateway)
+		return
+	}
+	writeJSON(w, domain)
+}
+
+func validRefreshParam(s string) bool {
+	return !strings.ContainsAny(s, "/\\\x00") && !strings.Contains(s, "..")
+}
+
+func (a *Admin) purge(w http.ResponseWriter, r *http.Request) {
+	key := r.URL.Query().Get("key")
+	if key == "" {
+		writeJSON(w, a.vs.NacosClientImpl.PurgeAll())
+		return
+	}
+
+	if !a.vs.NacosClientImpl.Purge(key) {
+		http.Error(w, "no such key: "+key, http.StatusNotFound)
+		return
+	}
+	writeJSON(w, []string{key})
+}
+
+func writeJSON(w http.ResponseWriter, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(v); err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+	}
+}
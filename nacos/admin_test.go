@@ -0,0 +1,197 @@
+/*
+ * Copyright 1999-2018 Alibaba Group Holding Ltd.
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *      http://www.apache.org/licenses/LICENSE-2.0
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package nacos
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/miekg/dns"
+	"github.com/nacos-group/nacos-coredns-plugin/nacos/nacostest"
+)
+
+func TestAdmin(t *testing.T) {
+	dir, err := ioutil.TempDir("", "nacos-admin")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	client := &NacosClient{domainMap: NewConcurrentMap(), indexMap: NewConcurrentMap(), cachePath: dir}
+	client.allDoms.Data = map[string]bool{"hello123": true, "world456": true}
+	client.domainMap.Set(GetCacheKey("hello123", "10.0.0.1"), Domain{Name: "hello123", Instances: []Instance{{IP: "2.2.2.2", Port: 80, Weight: 1, Valid: true}}})
+	client.domainMap.Set(GetCacheKey("world456", "10.0.0.1"), Domain{Name: "world456"})
+
+	vs := &Nacos{NacosClientImpl: client, DNSCache: NewConcurrentMap()}
+	rr, _ := dns.NewRR("www.example.org. 10 IN A 3.3.3.3")
+	vs.DNSCache.Set("www.example.org.1", DnsCache{Msg: &dns.Msg{Answer: []dns.RR{rr}}, TTL: 10})
+	a := NewAdmin("127.0.0.1:0", "secret", vs)
+
+	do := func(method, target, token string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(method, target, nil)
+		if token != "" {
+			req.Header.Set("Authorization", "Bearer "+token)
+		}
+		rec := httptest.NewRecorder()
+		a.ServeHTTP(rec, req)
+		return rec
+	}
+
+	if rec := do("GET", "/doms", ""); rec.Code != http.StatusUnauthorized {
+		t.Fatalf("expected 401 without token, got %d", rec.Code)
+	}
+	if rec := do("GET", "/doms", "wrong"); rec.Code != http.StatusUnauthorized {
+		t.Fatalf("expected 401 with wrong token, got %d", rec.Code)
+	}
+
+	var doms []string
+	json.Unmarshal(do("GET", "/doms", "secret").Body.Bytes(), &doms)
+	if len(doms) != 2 || doms[0] != "hello123" {
+		t.Fatalf("unexpected doms %v", doms)
+	}
+
+	if rec := do("GET", "/ready", "secret"); rec.Code != http.StatusServiceUnavailable {
+		t.Fatalf("expected 503 before the client is synced, got %d", rec.Code)
+	}
+	client.markSynced()
+	if rec := do("GET", "/ready", "secret"); rec.Code != http.StatusOK {
+		t.Fatalf("expected 200 once the client is synced, got %d", rec.Code)
+	}
+
+	var services map[string]Domain
+	json.Unmarshal(do("GET", "/services", "secret").Body.Bytes(), &services)
+	if services["hello123@@10.0.0.1"].Instances[0].IP != "2.2.2.2" {
+		t.Fatalf("unexpected services %v", services)
+	}
+
+	var upstream map[string]struct{ Answer []string }
+	json.Unmarshal(do("GET", "/upstream-cache", "secret").Body.Bytes(), &upstream)
+	if answer := upstream["www.example.org.1"].Answer; len(answer) != 1 || answer[0] != rr.String() {
+		t.Fatalf("unexpected upstream cache %v", upstream)
+	}
+
+	if rec := do("GET", "/purge", "secret"); rec.Code != http.StatusMethodNotAllowed {
+		t.Fatalf("expected 405 for GET /purge, got %d", rec.Code)
+	}
+	if rec := do("POST", "/purge?key=nothing@@", "secret"); rec.Code != http.StatusNotFound {
+		t.Fatalf("expected 404 for an unknown key, got %d", rec.Code)
+	}
+	if rec := do("POST", "/purge?key=hello123@@10.0.0.1", "secret"); rec.Code != http.StatusOK || client.domainMap.Has("hello123@@10.0.0.1") {
+		t.Fatalf("expected key to be purged, got %d", rec.Code)
+	}
+	if rec := do("POST", "/purge", "secret"); rec.Code != http.StatusOK || !client.domainMap.IsEmpty() {
+		t.Fatalf("expected all keys to be purged, got %d", rec.Code)
+	}
+	if rec := do("POST", "/refresh", "secret"); rec.Code != http.StatusBadRequest {
+		t.Fatalf("expected 400 for refresh without dom, got %d", rec.Code)
+	}
+}
+
+func TestAdmin_Refresh(t *testing.T) {
+	server := nacostest.NewServer()
+	defer server.Close()
+	server.SetService("hello123", nacostest.Instance{IP: "2.2.2.2", Port: 81, Weight: 1, Valid: true})
+
+	dir, err := ioutil.TempDir("", "nacos-admin")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	cacheDir := filepath.Join(dir, "cache")
+	os.Mkdir(cacheDir, 0755)
+
+	vc := NewNacosClientWithConfig(ClientConfig{Servers: []string{server.Host()}, ServerPort: server.Port(), CachePath: cacheDir})
+	a := NewAdmin("127.0.0.1:0", "", &Nacos{NacosClientImpl: vc, DNSCache: NewConcurrentMap()})
+	do := func(target string) *httptest.ResponseRecorder {
+		rec := httptest.NewRecorder()
+		a.ServeHTTP(rec, httptest.NewRequest("POST", target, nil))
+		return rec
+	}
+
+	var domain Domain
+	rec := do("/refresh?dom=hello123&clientIP=10.0.0.1")
+	json.Unmarshal(rec.Body.Bytes(), &domain)
+	if rec.Code != http.StatusOK || len(domain.Instances) != 1 || domain.Instances[0].IP != "2.2.2.2" {
+		t.Fatalf("unexpected refresh %d %s", rec.Code, rec.Body.String())
+	}
+	if rec := do("/refresh?dom=world456"); rec.Code != http.StatusNotFound {
+		t.Fatalf("expected 404 for a dom without instances, got %d", rec.Code)
+	}
+
+	for _, target := range []string{"/refresh?dom=../escape", "/refresh?dom=hello123&clientIP=../../escape", "/refresh?dom=a%2Fb", "/refresh?dom=..&clientIP=x"} {
+		if rec := do(target); rec.Code != http.StatusBadRequest {
+			t.Fatalf("expected 400 for %s, got %d", target, rec.Code)
+		}
+	}
+	if files, _ := ioutil.ReadDir(dir); len(files) != 1 {
+		t.Fatalf("expected nothing to be written outside of the cache dir, got %d files", len(files))
+	}
+
+	server.SetFailure(nacostest.PathSrvIPXT, http.StatusInternalServerError)
+	if rec := do("/refresh?dom=hello123"); rec.Code != http.StatusBadGateway {
+		t.Fatalf("expected 502 if nacos fails, got %d", rec.Code)
+	}
+}
+
+func TestAdmin_SharedListener(t *testing.T) {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	addr := l.Addr().String()
+	l.Close()
+
+	client := &NacosClient{domainMap: NewConcurrentMap(), indexMap: NewConcurrentMap(), cachePath: t.TempDir()}
+	client.allDoms.Data = map[string]bool{}
+	vs := &Nacos{NacosClientImpl: client, DNSCache: NewConcurrentMap()}
+	old, started := NewAdmin(addr, "old", vs), NewAdmin(addr, "new", vs)
+	if err := old.Start(); err != nil {
+		t.Fatal(err)
+	}
+	// like the instance started by a reload before the old one is shut down
+	if err := started.Start(); err != nil {
+		t.Fatalf("expected the listener to be shared, got %v", err)
+	}
+
+	// new connections only, a kept alive one outlives the listener
+	httpClient := &http.Client{Transport: &http.Transport{DisableKeepAlives: true}}
+	get := func(token string) int {
+		req, _ := http.NewRequest("GET", "http://"+addr+"/doms", nil)
+		req.Header.Set("Authorization", "Bearer "+token)
+		resp, err := httpClient.Do(req)
+		if err != nil {
+			return 0
+		}
+		resp.Body.Close()
+		return resp.StatusCode
+	}
+	if code := get("new"); code != http.StatusOK {
+		t.Fatalf("expected the API started last to answer, got %d", code)
+	}
+
+	started.Stop()
+	if code := get("old"); code != http.StatusOK {
+		t.Fatalf("expected the remaining API to answer, got %d", code)
+	}
+	old.Stop()
+	if code := get("old"); code != 0 {
+		t.Fatalf("expected the listener to be closed, got %d", code)
+	}
+}
@@ -0,0 +1,43 @@
+/*
+ * Copyright 1999-2018 Alibaba Group Holding Ltd.
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *      http://www.apache.org/licenses/LICENSE-2.0
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package nacos
+
+import "time"
+
+// Clock is the time source of a NacosClient, its ServerManager and the DNS
+// cache of the plugin. Tests replace it with a fake to expire and refresh
+// entries without sleeping, see nacostest.Clock.
+type Clock interface {
+	Now() time.Time
+	// After is time.After, the loops of the client wait with it.
+	After(d time.Duration) <-chan time.Time
+}
+
+// SystemClock is the Clock used unless another one is configured.
+var SystemClock Clock = systemClock{}
+
+type systemClock struct{}
+
+func (systemClock) Now() time.Time {
+	return time.Now()
+}
+
+func (systemClock) After(d time.Duration) <-chan time.Time {
+	return time.After(d)
+}
+
+// millis returns t in milliseconds since the epoch, like CurrentMillis.
+func millis(t time.Time) int64 {
+	return t.UnixNano() / 1e6
+}
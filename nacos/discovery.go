@@ -0,0 +1,158 @@
+/*
+ * Copyright 1999-2018 Alibaba Group Holding Ltd.
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *      http://www.apache.org/licenses/LICENSE-2.0
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package nacos
+
+import (
+	"context"
+	"reflect"
+	"sort"
+	"sync"
+
+	"github.com/cihub/seelog"
+)
+
+// ServiceDiscovery is the registry the plugin answers DNS queries from.
+// Services are named by their key, see Service.Key. NacosClient is the
+// registry of nacos, StaticDiscovery serves the services of a YAML file.
+type ServiceDiscovery interface {
+	// Managed reports whether dom is in the registry for clientIP.
+	Managed(dom, clientIP string) bool
+	// SrvInstances returns the valid instances of dom for clientIP, each as
+	// often as its weight like Domain.SrvInstances.
+	SrvInstances(dom, clientIP string) []Instance
+	// SrvInstanceContext returns the next of the SrvInstances of dom for
+	// clientIP, nil if there is none.
+	SrvInstanceContext(ctx context.Context, dom, clientIP string) *Instance
+	// DomsByIP returns the doms with a valid instance on ip.
+	DomsByIP(ip string) []string
+	// Subscribe calls listener whenever the instances of dom change and
+	// returns the id to Unsubscribe with.
+	Subscribe(dom string, listener ServiceListener) int
+	// Unsubscribe stops the calls of the listener subscribed with id.
+	Unsubscribe(dom string, id int)
+}
+
+// ServiceEvent is a change of the instances of a service.
+type ServiceEvent struct {
+	Dom string
+	// the client the instances are resolved for, empty for all clients
+	ClientIP string
+	Old      []Instance
+	New      []Instance
+}
+
+// ServiceListener is called with the changes of the services it is
+// subscribed to. It is called synchronously and must not block, a panic is
+// logged and does not reach the caller.
+type ServiceListener func(event ServiceEvent)
+
+// runner is implemented by the registries with work in the background.
+type runner interface {
+	Start(ctx context.Context) error
+	Stop() error
+}
+
+// listeners holds the listeners subscribed to the doms of a registry, its
+// zero value has none.
+type listeners struct {
+	lock   sync.RWMutex
+	lastID int
+	byDom  map[string]map[int]ServiceListener
+}
+
+func (l *listeners) subscribe(dom string, listener ServiceListener) int {
+	l.lock.Lock()
+	defer l.lock.Unlock()
+
+	if l.byDom == nil {
+		l.byDom = make(map[string]map[int]ServiceListener)
+	}
+	if l.byDom[dom] == nil {
+		l.byDom[dom] = make(map[int]ServiceListener)
+	}
+	l.lastID++
+	l.byDom[dom][l.lastID] = listener
+	return l.lastID
+}
+
+func (l *listeners) unsubscribe(dom string, id int) {
+	l.lock.Lock()
+	defer l.lock.Unlock()
+
+	delete(l.byDom[dom], id)
+	if len(l.byDom[dom]) == 0 {
+		delete(l.byDom, dom)
+	}
+}
+
+// doms returns the doms with listeners.
+func (l *listeners) doms() []string {
+	l.lock.RLock()
+	defer l.lock.RUnlock()
+
+	doms := make([]string, 0, len(l.byDom))
+	for dom := range l.byDom {
+		doms = append(doms, dom)
+	}
+	return doms
+}
+
+// notify calls the listeners of dom unless the instances are unchanged,
+// panics are logged to logger.
+func (l *listeners) notify(logger seelog.LoggerInterface, dom, clientIP string, old, new []Instance) {
+	if sameInstances(old, new) {
+		return
+	}
+
+	l.lock.RLock()
+	subscribed := make([]ServiceListener, 0, len(l.byDom[dom]))
+	for _, listener := range l.byDom[dom] {
+		subscribed = append(subscribed, listener)
+	}
+	l.lock.RUnlock()
+
+	event := ServiceEvent{Dom: dom, ClientIP: clientIP, Old: old, New: new}
+	for _, listener := range subscribed {
+		call(logger, listener, event)
+	}
+}
+
+// sameInstances reports whether old and new hold the same instances, in
+// any order. nacos does not keep the order of the instances of a dom.
+func sameInstances(old, new []Instance) bool {
+	if len(old) != len(new) {
+		return false
+	}
+
+	sorted := func(instances []Instance) []string {
+		s := make([]string, len(instances))
+		for i, instance := range instances {
+			s[i] = instance.String()
+		}
+		sort.Strings(s)
+		return s
+	}
+	return reflect.DeepEqual(sorted(old), sorted(new))
+}
+
+// call calls listener, the refresh loops and the push listener must
+// survive a panicking listener of code embedding the client.
+func call(logger seelog.LoggerInterface, listener ServiceListener, event ServiceEvent) {
+	defer func() {
+		if r := recover(); r != nil {
+			logger.Error("listener of "+event.Dom+" panicked: ", r)
+		}
+	}()
+	listener(event)
+}
@@ -0,0 +1,43 @@
+/*
+ * Copyright 1999-2018 Alibaba Group Holding Ltd.
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *      http://www.apache.org/licenses/LICENSE-2.0
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package nacos
+
+import "testing"
+
+func TestListeners_Notify(t *testing.T) {
+	var l listeners
+	var events []ServiceEvent
+	l.subscribe("hello123", func(event ServiceEvent) { panic("listener failed") })
+	l.subscribe("hello123", func(event ServiceEvent) { events = append(events, event) })
+	l.subscribe("world456", func(event ServiceEvent) { t.Fatal("expected only the listeners of hello123 to be called") })
+
+	instances := []Instance{{IP: "2.2.2.2", Port: 80, Weight: 1, Valid: true}}
+	l.notify(NacosClientLogger, "hello123", "", nil, []Instance{})
+	l.notify(NacosClientLogger, "hello123", "", instances, instances)
+	reordered := []Instance{{IP: "3.3.3.3", Port: 80, Weight: 1, Valid: true}, {IP: "2.2.2.2", Port: 80, Weight: 1, Valid: true}}
+	l.notify(NacosClientLogger, "hello123", "", []Instance{reordered[1], reordered[0]}, reordered)
+	if len(events) != 0 {
+		t.Fatalf("expected no events without changes, got %+v", events)
+	}
+	l.notify(NacosClientLogger, "hello123", "", reordered, []Instance{reordered[1], {IP: "3.3.3.3", Port: 80, Weight: 2, Valid: true}})
+	if len(events) != 1 {
+		t.Fatalf("expected an event for a changed weight, got %+v", events)
+	}
+	events = nil
+
+	l.notify(NacosClientLogger, "hello123", "10.0.0.1", nil, instances)
+	if len(events) != 1 || events[0].ClientIP != "10.0.0.1" || len(events[0].New) != 1 {
+		t.Fatalf("expected the event despite the panicking listener, got %+v", events)
+	}
+}
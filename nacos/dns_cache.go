@@ -21,9 +21,14 @@ import (
 type DnsCache struct {
 	Msg *dns.Msg
 	LastUpdateMills int64
+	TTL uint32
 }
 
 func (dnsCache *DnsCache) Updated() bool {
-	updated := (int)(time.Now().UnixNano() / 1000000 - dnsCache.LastUpdateMills) < (int)(DNSTTL * 1000)
-	return updated
+	return dnsCache.UpdatedAt(SystemClock.Now())
+}
+
+// UpdatedAt is Updated at the time now.
+func (dnsCache *DnsCache) UpdatedAt(now time.Time) bool {
+	return millis(now)-dnsCache.LastUpdateMills < int64(dnsCache.TTL)*1000
 }
@@ -20,15 +20,27 @@ import (
 )
 
 func TestDnsCache_Updated(t *testing.T) {
-	dnsCache := DnsCache{&dns.Msg{}, int64(100000)}
+	dnsCache := DnsCache{Msg: &dns.Msg{}, LastUpdateMills: int64(100000), TTL: 1}
 
 	if !dnsCache.Updated() {
 		t.Log("Out of date test is passed")
 	}
 
-	dnsCache = DnsCache{&dns.Msg{}, int64(time.Now().UnixNano())}
+	dnsCache = DnsCache{Msg: &dns.Msg{}, LastUpdateMills: int64(time.Now().UnixNano()), TTL: 1}
 
 	if dnsCache.Updated() {
 		t.Log("Updated is passed.")
 	}
 }
+
+func TestDnsCache_UpdatedAt(t *testing.T) {
+	now := time.Unix(1500000000, 0)
+	dnsCache := DnsCache{Msg: &dns.Msg{}, LastUpdateMills: now.UnixNano() / 1e6, TTL: 30}
+
+	if !dnsCache.UpdatedAt(now.Add(29 * time.Second)) {
+		t.Fatal("expected the entry to be updated within its TTL")
+	}
+	if dnsCache.UpdatedAt(now.Add(30 * time.Second)) {
+		t.Fatal("expected the entry to be out of date after its TTL")
+	}
+}
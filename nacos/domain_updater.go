@@ -18,10 +18,11 @@ import (
 )
 
 var domCache = DomCache{}
-var AllDoms AllDomsMap
-var indexMap = NewConcurrentMap()
 var serverManger = ServerManager{}
 
+// how often the names of all doms are refreshed if nacos does not tell otherwise.
+var DefaultAllDomsCacheSeconds = 30
+
 type AllDomsMap struct {
 	Data         map[string]bool
 	CacheSeconds int
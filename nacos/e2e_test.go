@@ -0,0 +1,217 @@
+/*
+ * Copyright 1999-2018 Alibaba Group Holding Ltd.
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *      http://www.apache.org/licenses/LICENSE-2.0
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package nacos
+
+import (
+	"context"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"os"
+	"testing"
+
+	"github.com/coredns/coredns/core/dnsserver"
+	"github.com/coredns/coredns/plugin/pkg/dnstest"
+	"github.com/coredns/coredns/plugin/test"
+	"github.com/mholt/caddy"
+	"github.com/miekg/dns"
+	"github.com/nacos-group/nacos-coredns-plugin/nacos/nacostest"
+)
+
+func init() {
+	// the plugin is only a known directive once build.sh added it to CoreDNS
+	for _, directive := range dnsserver.Directives {
+		if directive == "nacos" {
+			return
+		}
+	}
+	dnsserver.Directives = append(dnsserver.Directives, "nacos")
+}
+
+// localWriter is a test.ResponseWriter for a client on this host, so
+// answers are cached under the same key as pushes.
+type localWriter struct {
+	test.ResponseWriter
+}
+
+func (w *localWriter) RemoteAddr() net.Addr {
+	return &net.UDPAddr{IP: net.ParseIP("127.0.0.1"), Port: 40212}
+}
+
+// newE2EServer returns a fake nacos with two services and an excluded one.
+func newE2EServer() *nacostest.Server {
+	server := nacostest.NewServer()
+	server.SetService("hello123", nacostest.Instance{IP: "2.2.2.2", Port: 80, Weight: 1, Valid: true})
+	server.SetService("admin-console", nacostest.Instance{IP: "4.4.4.4", Port: 80, Weight: 1, Valid: true})
+	server.SetService("world456", nacostest.Instance{IP: "5.5.5.5", Port: 8080, Weight: 1, Valid: true})
+	return server
+}
+
+func assertAnswer(t *testing.T, m *dns.Msg, name, ip string, ttl uint32, port uint16) {
+	t.Helper()
+	if m.Rcode != dns.RcodeSuccess || !m.Authoritative {
+		t.Fatalf("expected an authoritative answer for %s, got %s", name, dns.RcodeToString[m.Rcode])
+	}
+	if len(m.Answer) != 1 {
+		t.Fatalf("expected one answer for %s, got %v", name, m.Answer)
+	}
+	a, ok := m.Answer[0].(*dns.A)
+	if !ok || a.Hdr.Name != name || a.A.String() != ip || a.Hdr.Ttl != ttl {
+		t.Fatalf("expected %s %d A %s, got %v", name, ttl, ip, m.Answer[0])
+	}
+	if len(m.Extra) != 1 {
+		t.Fatalf("expected the SRV record of %s, got %v", name, m.Extra)
+	}
+	srv, ok := m.Extra[0].(*dns.SRV)
+	if !ok || srv.Port != port || srv.Hdr.Ttl != ttl {
+		t.Fatalf("expected an SRV record with port %d, got %v", port, m.Extra[0])
+	}
+}
+
+func TestE2E_ServeDNS(t *testing.T) {
+	server := newE2EServer()
+	defer server.Close()
+
+	dir, err := ioutil.TempDir("", "nacos-e2e")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	client := NewNacosClientWithConfig(ClientConfig{Servers: []string{server.Host()}, ServerPort: server.Port(), CachePath: dir})
+	if err := client.Start(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	defer client.Stop()
+
+	exclude, _ := NewServiceMatcher("admin-*", "", "")
+	vs := &Nacos{NacosClientImpl: client, DNSCache: NewConcurrentMap(), TTL: 30, LogSample: 1,
+		Filter: ServiceFilter{Exclude: []ServiceMatcher{exclude}}}
+	vs.Fall.SetZonesFromArgs(nil)
+	vs.Next = test.NextHandler(dns.RcodeRefused, nil)
+
+	// serve returns an empty message if the plugin wrote none
+	serve := func(name string) (int, *dns.Msg) {
+		r := new(dns.Msg)
+		r.SetQuestion(name, dns.TypeA)
+		rec := dnstest.NewRecorder(&localWriter{})
+		rcode, err := vs.ServeDNS(context.TODO(), rec, r)
+		if err != nil {
+			t.Fatalf("unexpected error for %s: %v", name, err)
+		}
+		if rec.Msg == nil {
+			return rcode, new(dns.Msg)
+		}
+		return rcode, rec.Msg
+	}
+	answer := func(name string) *dns.Msg {
+		_, m := serve(name)
+		return m
+	}
+
+	assertAnswer(t, answer("hello123."), "hello123.", "2.2.2.2", 30, 80)
+	assertAnswer(t, answer("world456."), "world456.", "5.5.5.5", 30, 8080)
+
+	if rcode, m := serve("admin-console."); rcode != dns.RcodeNameError || m.Rcode != dns.RcodeNameError || len(m.Answer) != 0 {
+		t.Fatalf("expected NXDOMAIN for an excluded service, got %s %v", dns.RcodeToString[m.Rcode], m.Answer)
+	}
+	if rcode, _ := serve("www.example.org."); rcode != dns.RcodeRefused {
+		t.Fatalf("expected unknown names to fall through, got %s", dns.RcodeToString[rcode])
+	}
+
+	// the first query subscribed the plugin, the push replaces the instance
+	server.SetService("hello123", nacostest.Instance{IP: "3.3.3.3", Port: 81, Weight: 1, Valid: true})
+	if acked, err := server.Push("hello123"); err != nil || acked != 1 {
+		t.Fatalf("expected the push to be acked, got %d %v", acked, err)
+	}
+	assertAnswer(t, answer("hello123."), "hello123.", "3.3.3.3", 30, 81)
+}
+
+// startCoreDNS starts a CoreDNS server from corefile and returns its UDP address.
+func startCoreDNS(t *testing.T, corefile string) (*caddy.Instance, string) {
+	caddy.Quiet = true
+	dnsserver.Quiet = true
+
+	instance, err := caddy.Start(caddy.CaddyfileInput{Contents: []byte(corefile), Filepath: "Corefile", ServerTypeName: "dns"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, s := range instance.Servers() {
+		if addr, ok := s.Addr().(*net.UDPAddr); ok {
+			return instance, net.JoinHostPort("127.0.0.1", fmt.Sprint(addr.Port))
+		}
+	}
+	stopCoreDNS(instance)
+	t.Fatal("CoreDNS has no UDP listener")
+	return nil, ""
+}
+
+func stopCoreDNS(instance *caddy.Instance) {
+	instance.ShutdownCallbacks()
+	instance.Stop()
+}
+
+func TestE2E_Corefile(t *testing.T) {
+	server := newE2EServer()
+	defer server.Close()
+
+	dir, err := ioutil.TempDir("", "nacos-e2e")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	corefile := fmt.Sprintf(`.:0 {
+    nacos {
+        nacos_server %s
+        nacos_server_port %d
+        cache_dir %s
+        cache_ttl 15
+        log_output stderr
+        log_level error
+        exclude admin-*
+    }
+}
+`, server.Host(), server.Port(), dir)
+
+	instance, addr := startCoreDNS(t, corefile)
+	defer stopCoreDNS(instance)
+
+	exchange := func(name string) *dns.Msg {
+		m := new(dns.Msg)
+		m.SetQuestion(name, dns.TypeA)
+		r, err := dns.Exchange(m, addr)
+		if err != nil {
+			t.Fatalf("failed to query %s: %v", name, err)
+		}
+		return r
+	}
+
+	assertAnswer(t, exchange("hello123."), "hello123.", "2.2.2.2", 15, 80)
+
+	if r := exchange("admin-console."); r.Rcode != dns.RcodeNameError {
+		t.Fatalf("expected NXDOMAIN for an excluded service, got %s", dns.RcodeToString[r.Rcode])
+	}
+	// neither upstream nor fallthrough
+	if r := exchange("www.example.org."); r.Rcode != dns.RcodeServerFailure {
+		t.Fatalf("expected SERVFAIL without upstream, got %s", dns.RcodeToString[r.Rcode])
+	}
+
+	server.SetService("hello123", nacostest.Instance{IP: "3.3.3.3", Port: 81, Weight: 1, Valid: true})
+	if acked, err := server.Push("hello123"); err != nil || acked != 1 {
+		t.Fatalf("expected the push to be acked, got %d %v", acked, err)
+	}
+	assertAnswer(t, exchange("hello123."), "hello123.", "3.3.3.3", 15, 81)
+}
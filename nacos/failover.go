@@ -0,0 +1,161 @@
+/*
+ * Copyright 1999-2018 Alibaba Group Holding Ltd.
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *      http://www.apache.org/licenses/LICENSE-2.0
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package nacos
+
+import (
+	"context"
+	"io/ioutil"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/cihub/seelog"
+)
+
+var (
+	// same switch file name as the java client, so failover dirs can be shared.
+	FailoverSwitchFile = "00-00---000-VIPSRV_FAILOVER_SWITCH-000---00-00"
+	FailoverInterval   = 5 * time.Second
+)
+
+// FailoverReactor serves doms from files in a local directory instead of
+// the data pushed by nacos. It is only active while the switch file exists
+// in the directory and contains "1".
+type FailoverReactor struct {
+	dir       string
+	switchOn  bool
+	domains   map[string]Domain
+	signature string
+	lock      sync.RWMutex
+	// defaults to NacosClientLogger
+	logger seelog.LoggerInterface
+}
+
+func NewFailoverReactor(dir string) *FailoverReactor {
+	return &FailoverReactor{dir: dir, domains: make(map[string]Domain)}
+}
+
+// Domain returns the failover data of dom if failover is switched on.
+func (fr *FailoverReactor) Domain(dom string) (Domain, bool) {
+	if fr == nil {
+		return Domain{}, false
+	}
+
+	fr.lock.RLock()
+	defer fr.lock.RUnlock()
+
+	if !fr.switchOn {
+		return Domain{}, false
+	}
+
+	domain, ok := fr.domains[dom]
+	return domain, ok
+}
+
+// SwitchOn reports whether failover data is currently in use.
+func (fr *FailoverReactor) SwitchOn() bool {
+	if fr == nil {
+		return false
+	}
+
+	fr.lock.RLock()
+	defer fr.lock.RUnlock()
+	return fr.switchOn
+}
+
+func (fr *FailoverReactor) log() seelog.LoggerInterface {
+	if fr.logger == nil {
+		return NacosClientLogger
+	}
+	return fr.logger
+}
+
+func (fr *FailoverReactor) watch(ctx context.Context) {
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(FailoverInterval):
+		}
+		fr.refresh()
+	}
+}
+
+// refresh re-reads the switch file and, if it is on, the dom files.
+// The dom files are only parsed again when a file was added, removed or modified.
+func (fr *FailoverReactor) refresh() {
+	b, err := ioutil.ReadFile(fr.dir + string(os.PathSeparator) + FailoverSwitchFile)
+	switchOn := err == nil && strings.TrimSpace(string(b)) == "1"
+
+	fr.lock.Lock()
+	if switchOn != fr.switchOn {
+		fr.log().Info("failover switch is changed, on: " + strconv.FormatBool(switchOn) + ", dir: " + fr.dir)
+	}
+	fr.switchOn = switchOn
+	fr.lock.Unlock()
+
+	if !switchOn {
+		return
+	}
+
+	files, err := ioutil.ReadDir(fr.dir)
+	if err != nil {
+		fr.log().Error("failed to read failover dir: "+fr.dir, err)
+		return
+	}
+
+	signature := ""
+	for _, f := range files {
+		signature += f.Name() + ":" + strconv.FormatInt(f.ModTime().UnixNano(), 10) + ";"
+	}
+
+	fr.lock.RLock()
+	unchanged := signature == fr.signature
+	fr.lock.RUnlock()
+	if unchanged {
+		return
+	}
+
+	domains := make(map[string]Domain)
+	for _, f := range files {
+		if f.IsDir() || f.Name() == FailoverSwitchFile {
+			continue
+		}
+
+		fileName := fr.dir + string(os.PathSeparator) + f.Name()
+		b, err := ioutil.ReadFile(fileName)
+		if err != nil {
+			fr.log().Error("failed to read failover file: "+fileName, err)
+			continue
+		}
+
+		domain, err := processDomainString(string(b), fr.log())
+		if err != nil {
+			continue
+		}
+
+		// file names may be either "dom" or a cache key like "dom@@clientIP".
+		name, _ := SplitCacheKey(f.Name())
+		domains[name] = domain
+	}
+
+	fr.lock.Lock()
+	fr.domains = domains
+	fr.signature = signature
+	fr.lock.Unlock()
+
+	fr.log().Info("failover data is reloaded, total: " + strconv.Itoa(len(domains)))
+}
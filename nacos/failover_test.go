@@ -0,0 +1,54 @@
+/*
+ * Copyright 1999-2018 Alibaba Group Holding Ltd.
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *      http://www.apache.org/licenses/LICENSE-2.0
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package nacos
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestNacosClient_Failover(t *testing.T) {
+	dir, err := ioutil.TempDir("", "nacos-failover")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	s := `{"dom":"hello123","cacheMillis":10000,"hosts":[{"valid":true,"port":80,"ip":"3.3.3.3","weight":1.0}]}`
+	ioutil.WriteFile(filepath.Join(dir, "hello123"), []byte(s), 0666)
+
+	vc := NacosClient{domainMap: NewConcurrentMap(), indexMap: NewConcurrentMap(), cachePath: t.TempDir(), serverPort: 8848}
+	vc.domainMap.Set(GetCacheKey("hello123", "127.0.0.1"), Domain{Name: "hello123",
+		Instances: []Instance{{IP: "2.2.2.2", Port: 80, Weight: 1, Valid: true}}})
+	vc.failover = NewFailoverReactor(dir)
+
+	vc.failover.refresh()
+	if ip := vc.SrvInstance("hello123", "127.0.0.1").IP; ip != "2.2.2.2" {
+		t.Fatalf("expected cached instance while failover is off, got %s", ip)
+	}
+
+	ioutil.WriteFile(filepath.Join(dir, FailoverSwitchFile), []byte("1"), 0666)
+	vc.failover.refresh()
+	if ip := vc.SrvInstance("hello123", "127.0.0.1").IP; ip != "3.3.3.3" {
+		t.Fatalf("expected failover instance while failover is on, got %s", ip)
+	}
+
+	ioutil.WriteFile(filepath.Join(dir, FailoverSwitchFile), []byte("0"), 0666)
+	vc.failover.refresh()
+	if instances := vc.SrvInstances("hello123", "127.0.0.1"); instances[0].IP != "2.2.2.2" {
+		t.Fatalf("expected cached instance after failover is off, got %s", instances[0].IP)
+	}
+}
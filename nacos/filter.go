@@ -0,0 +1,120 @@
+/*
+ * Copyright 1999-2018 Alibaba Group Holding Ltd.
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *      http://www.apache.org/licenses/LICENSE-2.0
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package nacos
+
+import (
+	"path"
+	"regexp"
+	"strings"
+)
+
+// group and namespace of services registered without one
+var (
+	DefaultGroup     = "DEFAULT_GROUP"
+	DefaultNamespace = "public"
+)
+
+// ServiceMatcher matches the service, group and namespace of a service.
+// A nil field matches everything.
+type ServiceMatcher struct {
+	Service   func(string) bool
+	Group     func(string) bool
+	Namespace func(string) bool
+}
+
+// NewServiceMatcher returns a matcher for patterns that are either globs
+// or regexes enclosed in slashes, e.g. admin-* or /^admin-.*$/.
+// Empty patterns match everything.
+func NewServiceMatcher(service, group, namespace string) (ServiceMatcher, error) {
+	var m ServiceMatcher
+	var err error
+	if m.Service, err = newPatternMatcher(service); err != nil {
+		return m, err
+	}
+	if m.Group, err = newPatternMatcher(group); err != nil {
+		return m, err
+	}
+	m.Namespace, err = newPatternMatcher(namespace)
+	return m, err
+}
+
+func newPatternMatcher(pattern string) (func(string) bool, error) {
+	if pattern == "" {
+		return nil, nil
+	}
+
+	if len(pattern) > 1 && strings.HasPrefix(pattern, "/") && strings.HasSuffix(pattern, "/") {
+		re, err := regexp.Compile(pattern[1 : len(pattern)-1])
+		if err != nil {
+			return nil, err
+		}
+		return re.MatchString, nil
+	}
+
+	// reject malformed globs now, path.Match only reports them when they are used
+	if _, err := path.Match(pattern, ""); err != nil {
+		return nil, err
+	}
+	return func(s string) bool {
+		ok, _ := path.Match(pattern, s)
+		return ok
+	}, nil
+}
+
+// Match reports whether s is matched. Services without group or namespace
+// are matched as if they were in DefaultGroup and DefaultNamespace.
+func (m ServiceMatcher) Match(s Service) bool {
+	group, namespace := s.Group, s.Namespace
+	if group == "" {
+		group = DefaultGroup
+	}
+	if namespace == "" {
+		namespace = DefaultNamespace
+	}
+
+	return matches(m.Service, s.Name) && matches(m.Group, group) && matches(m.Namespace, namespace)
+}
+
+func matches(match func(string) bool, s string) bool {
+	return match == nil || match(s)
+}
+
+// ServiceFilter decides which services are exposed over DNS.
+type ServiceFilter struct {
+	// if not empty, only services matching one of these are exposed
+	Include []ServiceMatcher
+	// services matching one of these are never exposed
+	Exclude []ServiceMatcher
+}
+
+// Allowed reports whether s may be exposed.
+func (f ServiceFilter) Allowed(s Service) bool {
+	included := len(f.Include) == 0
+	for _, m := range f.Include {
+		if m.Match(s) {
+			included = true
+			break
+		}
+	}
+	if !included {
+		return false
+	}
+
+	for _, m := range f.Exclude {
+		if m.Match(s) {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,63 @@
+/*
+ * Copyright 1999-2018 Alibaba Group Holding Ltd.
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *      http://www.apache.org/licenses/LICENSE-2.0
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package nacos
+
+import (
+	"testing"
+)
+
+func TestServiceFilter(t *testing.T) {
+	mustMatcher := func(service, group, namespace string) ServiceMatcher {
+		m, err := NewServiceMatcher(service, group, namespace)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return m
+	}
+
+	f := ServiceFilter{
+		Include: []ServiceMatcher{mustMatcher("", "", "public"), mustMatcher("", "APP_GROUP", "dev")},
+		Exclude: []ServiceMatcher{mustMatcher("admin-*", "", ""), mustMatcher("/^internal\\./", "", "")},
+	}
+
+	tests := []struct {
+		service  Service
+		expected bool
+	}{
+		{Service{Name: "hello123"}, true},
+		{Service{Name: "hello123", Group: "DEFAULT_GROUP", Namespace: "public"}, true},
+		{Service{Name: "admin-console"}, false},
+		{Service{Name: "internal.metrics"}, false},
+		{Service{Name: "hello123", Namespace: "dev"}, false},
+		{Service{Name: "hello123", Group: "APP_GROUP", Namespace: "dev"}, true},
+		{Service{Name: "admin-console", Group: "APP_GROUP", Namespace: "dev"}, false},
+	}
+
+	for _, test := range tests {
+		if allowed := f.Allowed(test.service); allowed != test.expected {
+			t.Errorf("expected %+v to be allowed: %v, got %v", test.service, test.expected, allowed)
+		}
+	}
+
+	if !(ServiceFilter{}).Allowed(Service{Name: "admin-console"}) {
+		t.Fatal("expected everything to be allowed without rules")
+	}
+
+	if _, err := NewServiceMatcher("[admin", "", ""); err == nil {
+		t.Fatal("expected error for malformed glob")
+	}
+	if _, err := NewServiceMatcher("/(admin/", "", ""); err == nil {
+		t.Fatal("expected error for malformed regex")
+	}
+}
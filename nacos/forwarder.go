@@ -0,0 +1,256 @@
+/*
+ * Copyright 1999-2018 Alibaba Group Holding Ltd.
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *      http://www.apache.org/licenses/LICENSE-2.0
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package nacos
+
+import (
+	"crypto/tls"
+	"math/rand"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	"github.com/cihub/seelog"
+	"github.com/coredns/coredns/request"
+	"github.com/miekg/dns"
+)
+
+const (
+	PolicyRandom     = "random"
+	PolicyRoundRobin = "round_robin"
+	PolicySequential = "sequential"
+)
+
+var (
+	DefaultHealthCheckInterval = 500 * time.Millisecond
+	DefaultMaxFails            = uint32(2)
+	DefaultUpstreamTimeout     = 2 * time.Second
+)
+
+// Upstream is a single upstream DNS server and its health state.
+// Plain DNS upstreams are addressed as host:port, DNS-over-TLS upstreams
+// as tls://host:port and DNS-over-HTTPS upstreams by their https:// URL.
+type Upstream struct {
+	addr      string
+	transport string
+	fails     uint32
+
+	tlsConfig  *tls.Config
+	conns      chan *dns.Conn
+	httpClient *http.Client
+}
+
+func NewUpstream(addr string) *Upstream {
+	u := &Upstream{addr: addr, transport: TransportDNS}
+
+	switch {
+	case strings.HasPrefix(addr, "tls://"):
+		u.transport = TransportTLS
+		u.addr = strings.TrimPrefix(addr, "tls://")
+		u.conns = make(chan *dns.Conn, MaxIdleTLSConns)
+	case strings.HasPrefix(addr, "https://"):
+		u.transport = TransportHTTPS
+	}
+
+	u.SetTLSConfig(&tls.Config{ClientSessionCache: tls.NewLRUClientSessionCache(0)})
+	return u
+}
+
+// Addr returns the address of the upstream including its scheme, if any.
+func (u *Upstream) Addr() string {
+	if u.transport == TransportTLS {
+		return "tls://" + u.addr
+	}
+	return u.addr
+}
+
+// Down reports whether the upstream failed maxFails times in a row.
+// A maxFails of 0 never marks an upstream down.
+func (u *Upstream) Down(maxFails uint32) bool {
+	if maxFails == 0 {
+		return false
+	}
+	return atomic.LoadUint32(&u.fails) >= maxFails
+}
+
+func (u *Upstream) exchange(m *dns.Msg, proto string, timeout time.Duration) (*dns.Msg, error) {
+	switch u.transport {
+	case TransportTLS:
+		return u.exchangeTLS(m, timeout)
+	case TransportHTTPS:
+		return u.exchangeHTTPS(m, timeout)
+	}
+
+	client := dns.Client{Net: proto, Timeout: timeout}
+	reply, _, err := client.Exchange(m, u.addr)
+	if err == nil && reply.Truncated && proto != "tcp" {
+		client.Net = "tcp"
+		reply, _, err = client.Exchange(m, u.addr)
+	}
+	return reply, err
+}
+
+func (u *Upstream) check(timeout time.Duration, logger seelog.LoggerInterface) {
+	m := new(dns.Msg)
+	m.SetQuestion(".", dns.TypeNS)
+	m.RecursionDesired = false
+
+	if _, err := u.exchange(m, "udp", timeout); err != nil {
+		fails := atomic.AddUint32(&u.fails, 1)
+		logger.Warn("health check of upstream "+u.Addr()+" failed, fails: "+strconv.Itoa(int(fails)), err)
+		return
+	}
+	atomic.StoreUint32(&u.fails, 0)
+}
+
+// Forwarder sends queries for doms not registered in nacos to a set of
+// upstream DNS servers. Upstreams are chosen by Policy, upstreams that
+// failed MaxFails times are skipped until a health check succeeds again.
+type Forwarder struct {
+	upstreams []*Upstream
+	cursor    uint32
+	stop      chan struct{}
+
+	Policy      string
+	MaxFails    uint32
+	HealthCheck time.Duration
+	Timeout     time.Duration
+	// defaults to NacosClientLogger
+	Logger seelog.LoggerInterface
+}
+
+func NewForwarder(addrs []string) *Forwarder {
+	f := &Forwarder{
+		Policy:      PolicyRandom,
+		MaxFails:    DefaultMaxFails,
+		HealthCheck: DefaultHealthCheckInterval,
+		Timeout:     DefaultUpstreamTimeout,
+	}
+	for _, addr := range addrs {
+		f.upstreams = append(f.upstreams, NewUpstream(addr))
+	}
+	return f
+}
+
+func (f *Forwarder) logger() seelog.LoggerInterface {
+	if f.Logger == nil {
+		return NacosClientLogger
+	}
+	return f.Logger
+}
+
+func (f *Forwarder) Upstreams() []*Upstream {
+	return f.upstreams
+}
+
+// StartHealthCheck checks the upstreams every HealthCheck interval until Stop is called.
+func (f *Forwarder) StartHealthCheck() {
+	if f.HealthCheck <= 0 || f.MaxFails == 0 || f.stop != nil {
+		return
+	}
+
+	stop := make(chan struct{})
+	f.stop = stop
+	go func() {
+		for {
+			select {
+			case <-stop:
+				return
+			case <-time.After(f.HealthCheck):
+			}
+			for _, u := range f.upstreams {
+				if atomic.LoadUint32(&u.fails) > 0 {
+					u.check(f.Timeout, f.logger())
+				}
+			}
+		}
+	}()
+}
+
+// Stop stops the health checks and closes idle connections to the upstreams.
+func (f *Forwarder) Stop() {
+	if f.stop != nil {
+		close(f.stop)
+		f.stop = nil
+	}
+	for _, u := range f.upstreams {
+		u.closeIdle()
+	}
+}
+
+// list returns the upstreams in the order they should be tried.
+func (f *Forwarder) list() []*Upstream {
+	n := len(f.upstreams)
+	ordered := make([]*Upstream, 0, n)
+
+	switch f.Policy {
+	case PolicySequential:
+		ordered = append(ordered, f.upstreams...)
+	case PolicyRoundRobin:
+		start := int(atomic.AddUint32(&f.cursor, 1) % uint32(n))
+		for i := 0; i < n; i++ {
+			ordered = append(ordered, f.upstreams[(start+i)%n])
+		}
+	default:
+		for _, i := range rand.Perm(n) {
+			ordered = append(ordered, f.upstreams[i])
+		}
+	}
+
+	return ordered
+}
+
+// Lookup implements the ServiceBackend interface.
+func (f *Forwarder) Lookup(state request.Request, name string, typ uint16) (*dns.Msg, error) {
+	m := new(dns.Msg)
+	m.SetQuestion(name, typ)
+	m.RecursionDesired = true
+
+	return f.Exchange(m, state.Proto())
+}
+
+// Exchange sends m to the healthy upstreams until one of them answers.
+// If all upstreams are down they are all tried anyway.
+func (f *Forwarder) Exchange(m *dns.Msg, proto string) (*dns.Msg, error) {
+	if len(f.upstreams) == 0 {
+		return nil, NacosClientError{"no upstream configured"}
+	}
+
+	ordered := f.list()
+	healthy := make([]*Upstream, 0, len(ordered))
+	for _, u := range ordered {
+		if !u.Down(f.MaxFails) {
+			healthy = append(healthy, u)
+		}
+	}
+	if len(healthy) == 0 {
+		healthy = ordered
+	}
+
+	var lastErr error
+	for _, u := range healthy {
+		reply, err := u.exchange(m, proto, f.Timeout)
+		if err == nil {
+			atomic.StoreUint32(&u.fails, 0)
+			return reply, nil
+		}
+
+		atomic.AddUint32(&u.fails, 1)
+		f.logger().Warn("failed to forward "+m.Question[0].Name+" to upstream "+u.Addr(), err)
+		lastErr = err
+	}
+
+	return nil, lastErr
+}
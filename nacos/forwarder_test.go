@@ -0,0 +1,121 @@
+/*
+ * Copyright 1999-2018 Alibaba Group Holding Ltd.
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *      http://www.apache.org/licenses/LICENSE-2.0
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package nacos
+
+import (
+	"net"
+	"testing"
+	"time"
+
+	"github.com/miekg/dns"
+)
+
+// startUpstream starts a DNS server on udp and tcp that answers every A query with ip.
+// Answers over udp are truncated if truncate is set.
+func startUpstream(t *testing.T, ip string, truncate bool) (string, func()) {
+	return startUpstreamHandler(t, func(proto string) dns.HandlerFunc {
+		return func(w dns.ResponseWriter, r *dns.Msg) {
+			m := new(dns.Msg)
+			m.SetReply(r)
+			if truncate && proto == "udp" {
+				m.Truncated = true
+			} else {
+				rr, _ := dns.NewRR(r.Question[0].Name + " 10 IN A " + ip)
+				m.Answer = append(m.Answer, rr)
+			}
+			w.WriteMsg(m)
+		}
+	})
+}
+
+// startUpstreamHandler starts an upstream answering with the handler of each protocol.
+func startUpstreamHandler(t *testing.T, handler func(proto string) dns.HandlerFunc) (string, func()) {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	pc, err := net.ListenPacket("udp", l.Addr().String())
+	if err != nil {
+		l.Close()
+		t.Skip("can not listen udp and tcp on the same port: ", err)
+	}
+
+	udpServer := &dns.Server{PacketConn: pc, Handler: handler("udp")}
+	tcpServer := &dns.Server{Listener: l, Handler: handler("tcp")}
+	go udpServer.ActivateAndServe()
+	go tcpServer.ActivateAndServe()
+
+	return l.Addr().String(), func() {
+		udpServer.Shutdown()
+		tcpServer.Shutdown()
+	}
+}
+
+func query(name string) *dns.Msg {
+	m := new(dns.Msg)
+	m.SetQuestion(name, dns.TypeA)
+	return m
+}
+
+func TestForwarder_Exchange(t *testing.T) {
+	addr, stop := startUpstream(t, "2.2.2.2", false)
+	defer stop()
+
+	// nothing listens on the first upstream
+	f := NewForwarder([]string{"127.0.0.1:1", addr})
+	f.Policy = PolicySequential
+	f.Timeout = 200 * time.Millisecond
+
+	for i := 0; i < 3; i++ {
+		reply, err := f.Exchange(query("example.org."), "udp")
+		if err != nil {
+			t.Fatal("expected reply from the healthy upstream, ", err)
+		}
+		if reply.Answer[0].(*dns.A).A.String() != "2.2.2.2" {
+			t.Fatalf("unexpected answer %v", reply.Answer)
+		}
+	}
+
+	if !f.Upstreams()[0].Down(f.MaxFails) {
+		t.Fatal("expected the failing upstream to be down")
+	}
+	if f.Upstreams()[1].Down(f.MaxFails) {
+		t.Fatal("expected the healthy upstream to be up")
+	}
+}
+
+func TestForwarder_TruncatedFallbackToTCP(t *testing.T) {
+	addr, stop := startUpstream(t, "3.3.3.3", true)
+	defer stop()
+
+	f := NewForwarder([]string{addr})
+	reply, err := f.Exchange(query("example.org."), "udp")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if reply.Truncated || len(reply.Answer) != 1 {
+		t.Fatalf("expected full answer over tcp, got %v", reply)
+	}
+}
+
+func TestForwarder_RoundRobin(t *testing.T) {
+	f := NewForwarder([]string{"1.1.1.1:53", "2.2.2.2:53", "3.3.3.3:53"})
+	f.Policy = PolicyRoundRobin
+
+	first := f.list()[0].Addr()
+	second := f.list()[0].Addr()
+	if first == second {
+		t.Fatalf("expected round robin to rotate upstreams, got %s twice", first)
+	}
+}
@@ -0,0 +1,113 @@
+//go:build go1.18
+// +build go1.18
+
+/*
+ * Copyright 1999-2018 Alibaba Group Holding Ltd.
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *      http://www.apache.org/licenses/LICENSE-2.0
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package nacos
+
+import (
+	"bytes"
+	"compress/gzip"
+	"io/ioutil"
+	"path/filepath"
+	"testing"
+
+	"github.com/cihub/seelog"
+	"github.com/nacos-group/nacos-coredns-plugin/nacos/nacoscache"
+)
+
+// addSeeds adds the payloads in testdata matching pattern to the corpus of
+// f, plain and gzipped like nacos sends pushes larger than a kilobyte.
+func addSeeds(f *testing.F, pattern string, add func(data []byte)) {
+	files, err := filepath.Glob(filepath.Join("testdata", pattern))
+	if err != nil || len(files) == 0 {
+		f.Fatalf("no seeds for %s: %v", pattern, err)
+	}
+
+	for _, file := range files {
+		data, err := ioutil.ReadFile(file)
+		if err != nil {
+			f.Fatal(err)
+		}
+		add(data)
+
+		var buf bytes.Buffer
+		w := gzip.NewWriter(&buf)
+		w.Write(data)
+		w.Close()
+		add(buf.Bytes())
+	}
+}
+
+// quietFuzz keeps the logs of rejected inputs out of the fuzzer output.
+func quietFuzz(f *testing.F) {
+	logger := NacosClientLogger
+	NacosClientLogger = seelog.Disabled
+	f.Cleanup(func() { NacosClientLogger = logger })
+}
+
+func FuzzTryDecompressData(f *testing.F) {
+	quietFuzz(f)
+	addSeeds(f, "*.json", func(data []byte) { f.Add(data) })
+	f.Add([]byte("\x1f\x8b"))
+
+	f.Fuzz(func(t *testing.T, data []byte) {
+		if s := TryDecompressData(data); int64(len(s)) > MaxDecompressedSize && IsGzipFile(data) {
+			t.Fatalf("decompressed %d bytes, more than %d", len(s), MaxDecompressedSize)
+		}
+	})
+}
+
+func FuzzProcessDomainString(f *testing.F) {
+	quietFuzz(f)
+	addSeeds(f, "srvIPXT-*.json", func(data []byte) { f.Add(string(data)) })
+	f.Add(`{"dom":"hello123","hosts":[{"ip":"2.2.2.2","weight":1e308,"valid":true},{"ip":"3.3.3.3","weight":1e308,"valid":true}]}`)
+
+	f.Fuzz(func(t *testing.T, s string) {
+		domain, err := ProcessDomainString(s)
+		if err != nil {
+			return
+		}
+		instances := domain.SrvInstances()
+		if len(instances) > nacoscache.MaxSrvInstances+len(domain.Instances) {
+			t.Fatalf("%d instances for %d hosts", len(instances), len(domain.Instances))
+		}
+		for _, host := range domain.Instances {
+			if host.Valid && host.Weight > 0 && len(instances) == 0 {
+				t.Fatalf("no instances for valid host %v", host)
+			}
+		}
+	})
+}
+
+func FuzzHandlePush(f *testing.F) {
+	quietFuzz(f)
+	addSeeds(f, "push-*.json", func(data []byte) { f.Add(data) })
+	f.Add([]byte(`{"type":"dom","data":"{\"hosts\":[{\"ip\":\"2.2.2.2\"}]}","lastRefTime":1}`))
+
+	dir := f.TempDir()
+	f.Fuzz(func(t *testing.T, data []byte) {
+		client := &NacosClient{domainMap: NewConcurrentMap(), indexMap: NewConcurrentMap(), cachePath: dir, logger: seelog.Disabled}
+		us := UDPServer{vipClient: client}
+		if _, ok := us.handlePush(data); !ok {
+			return
+		}
+
+		// whatever was cached must be answerable
+		for _, item := range client.domainMap.Items() {
+			item.(Domain).SrvInstances()
+		}
+		client.DomsByIP("2.2.2.2")
+	})
+}
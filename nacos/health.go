@@ -0,0 +1,73 @@
+/*
+ * Copyright 1999-2018 Alibaba Group Holding Ltd.
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *      http://www.apache.org/licenses/LICENSE-2.0
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package nacos
+
+import (
+	"sync/atomic"
+	"time"
+)
+
+// how long the plugin stays healthy without any response from nacos
+var DefaultHealthWindow = 60 * time.Second
+
+// Synced reports whether the client knows the registered doms, either from
+// nacos or from the cache dir.
+func (vc *NacosClient) Synced() bool {
+	return atomic.LoadInt32(&vc.synced) == 1
+}
+
+func (vc *NacosClient) markSynced() {
+	if atomic.CompareAndSwapInt32(&vc.synced, 0, 1) {
+		Inited = true
+		vc.Logger().Info("nacos client is synced")
+	}
+}
+
+// LastContact returns when a nacos server last responded, or when the
+// client was started if none has responded since.
+func (vc *NacosClient) LastContact() time.Time {
+	return time.Unix(0, atomic.LoadInt64(&vc.lastContact)*int64(time.Millisecond))
+}
+
+func (vc *NacosClient) markContact() {
+	atomic.StoreInt64(&vc.lastContact, vc.now())
+}
+
+// Ready implements the ready.Readiness interface of CoreDNS 1.5.0 and later,
+// the plugin is ready once the nacos client is synced and the prefetch, if
+// any, is done or timed out. The admin API reports it on GET /ready as well.
+func (vs *Nacos) Ready() bool {
+	if vs.NacosClientImpl == nil {
+		// other registries are loaded before the plugin is set up
+		return true
+	}
+	if vs.config != nil && vs.config.Prefetch && atomic.LoadInt32(&vs.prefetched) == 0 {
+		return false
+	}
+	return vs.NacosClientImpl.Synced()
+}
+
+// Health implements the health.Healther interface, the plugin is healthy
+// while a nacos server has responded within HealthWindow. It is always
+// healthy without a nacos client.
+func (vs *Nacos) Health() bool {
+	if vs.NacosClientImpl == nil {
+		return true
+	}
+	window := vs.HealthWindow
+	if window == 0 {
+		window = DefaultHealthWindow
+	}
+	return vs.NacosClientImpl.Clock().Now().Sub(vs.NacosClientImpl.LastContact()) <= window
+}
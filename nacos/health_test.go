@@ -0,0 +1,108 @@
+/*
+ * Copyright 1999-2018 Alibaba Group Holding Ltd.
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *      http://www.apache.org/licenses/LICENSE-2.0
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package nacos
+
+import (
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/nacos-group/nacos-coredns-plugin/nacos/nacostest"
+)
+
+func TestNacos_Ready(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		if req.URL.EscapedPath() == "/nacos/v1/ns/api/allDomNames" {
+			w.Write([]byte("{\"count\":1,\"doms\":[\"hello123\"]}"))
+		}
+	}))
+	defer server.Close()
+
+	dir, err := ioutil.TempDir("", "nacos-ready")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	host := strings.TrimPrefix(server.URL, "http://")
+	port, _ := strconv.Atoi(strings.Split(host, ":")[1])
+	vs := Nacos{NacosClientImpl: NewNacosClientWithConfig(ClientConfig{Servers: []string{strings.Split(host, ":")[0]}, ServerPort: port, CachePath: dir})}
+
+	if vs.Ready() {
+		t.Fatal("expected not ready before the doms are fetched")
+	}
+	vs.NacosClientImpl.getAllDomNames()
+	if !vs.Ready() {
+		t.Fatal("expected ready after the doms are fetched")
+	}
+
+	vs.config = &Config{Prefetch: true, PrefetchTimeout: time.Second}
+	if vs.Ready() {
+		t.Fatal("expected not ready before the prefetch is done")
+	}
+	if err := vs.OnStartup(); err != nil {
+		t.Fatal(err)
+	}
+	defer vs.OnShutdown()
+	if !vs.Ready() {
+		t.Fatal("expected ready after the prefetch is done")
+	}
+
+	// a restart with the doms in the cache dir is ready right away
+	s := `{"dom":"hello123","cacheMillis":10000,"hosts":[{"valid":true,"port":80,"ip":"2.2.2.2","weight":1.0}]}`
+	ioutil.WriteFile(filepath.Join(dir, GetCacheKey("hello123", "")), []byte(s), 0666)
+	if !NewNacosClientWithConfig(ClientConfig{CachePath: dir}).Synced() {
+		t.Fatal("expected ready after restoring the cache")
+	}
+}
+
+func TestNacos_Health(t *testing.T) {
+	vs := Nacos{NacosClientImpl: &NacosClient{}, HealthWindow: time.Minute}
+
+	if vs.Health() {
+		t.Fatal("expected unhealthy before start")
+	}
+
+	vs.NacosClientImpl.markContact()
+	if !vs.Health() {
+		t.Fatal("expected healthy after a response")
+	}
+
+	vs.NacosClientImpl.lastContact -= 2 * time.Minute.Nanoseconds() / int64(time.Millisecond)
+	if vs.Health() {
+		t.Fatal("expected unhealthy without a response within the window")
+	}
+}
+
+func TestNacos_HealthClock(t *testing.T) {
+	clock := nacostest.NewClock(time.Unix(1500000000, 0))
+	vs := Nacos{NacosClientImpl: &NacosClient{clock: clock}, HealthWindow: time.Minute}
+
+	vs.NacosClientImpl.markContact()
+	clock.Advance(time.Minute)
+	if !vs.Health() {
+		t.Fatal("expected healthy at the end of the window")
+	}
+
+	clock.Advance(time.Millisecond)
+	if vs.Health() {
+		t.Fatal("expected unhealthy after the window")
+	}
+}
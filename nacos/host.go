@@ -1,36 +0,0 @@
-/*
- * Copyright 1999-2018 Alibaba Group Holding Ltd.
- * Licensed under the Apache License, Version 2.0 (the "License");
- * you may not use this file except in compliance with the License.
- * You may obtain a copy of the License at
- *      http://www.apache.org/licenses/LICENSE-2.0
- * Unless required by applicable law or agreed to in writing, software
- * distributed under the License is distributed on an "AS IS" BASIS,
- * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
- * See the License for the specific language governing permissions and
- * limitations under the License.
- */
-
-package nacos
-
-import "encoding/json"
-
-type Instance struct {
-	IP string
-	Port int
-	Weight float64
-	Valid bool
-	Unit string
-	AppUseType string
-	Site string
-}
-
-func (h Instance) String() string {
-	bs, err := json.Marshal(&h)
-
-	if err != nil {
-		return ""
-	}
-
-	return string(bs)
-}
@@ -14,12 +14,16 @@
 package nacos
 
 import (
+	"context"
 	"net/http"
 	"time"
 	"strings"
 	"io/ioutil"
 	"strconv"
 	"net/url"
+
+	"github.com/cihub/seelog"
+	"github.com/opentracing/opentracing-go/ext"
 )
 
 var httpClient = http.Client{
@@ -27,8 +31,6 @@ var httpClient = http.Client{
 }
 
 func encodeUrl(urlString string, params map[string]string) string {
-	params["udpPort"] = strconv.Itoa(UDP_Port)
-
 	if !strings.HasSuffix(urlString, "?") {
 		urlString += "?"
 	}
@@ -46,40 +48,74 @@ func encodeUrl(urlString string, params map[string]string) string {
 }
 
 func Get(url string, params map[string]string) string {
+	return GetWithContext(context.Background(), url, params)
+}
+
+// GetWithContext is Get as part of the trace in ctx.
+func GetWithContext(ctx context.Context, url string, params map[string]string) string {
+	s, _ := Request(ctx, "GET", url, params)
+	return s
+}
+
+// Request sends a request with params in the query to nacos and returns
+// the body of the response, anything but 200 is an error.
+func Request(ctx context.Context, method, url string, params map[string]string) (string, error) {
+	return request(ctx, NacosClientLogger, method, url, params)
+}
+
+// request is Request logging to the logger of a client.
+func request(ctx context.Context, logger seelog.LoggerInterface, method, url string, params map[string]string) (string, error) {
 	if params == nil {
 		params = make(map[string]string)
 	}
 
 	url = encodeUrl(url, params)
 
-	req, err := http.NewRequest("GET",url, nil)
+	req, err := http.NewRequest(method, url, nil)
 	if err != nil {
-		NacosClientLogger.Error("failed to build request", err)
-		return ""
+		logger.Error("failed to build request", err)
+		return "", err
 	}
 
+	req = req.WithContext(ctx)
 	req.Header.Add("Client-Version", Version)
+	server := req.URL.Host
+	span, _ := startSpan(ctx, SpanRequest)
+	defer span.Finish()
+	span.SetTag("server", server)
+	ext.HTTPMethod.Set(span, req.Method)
+	ext.HTTPUrl.Set(span, req.URL.Path)
+
+	start := time.Now()
 	response, err := httpClient.Do(req)
+	ServerRequestCount.WithLabelValues(server).Inc()
+	ServerRequestDuration.WithLabelValues(server).Observe(time.Since(start).Seconds())
+	if response != nil {
+		defer response.Body.Close()
+		ext.HTTPStatusCode.Set(span, uint16(response.StatusCode))
+	}
 
 	if err != nil || response.StatusCode != 200 {
-		NacosClientLogger.Error("error while request from " + url, err)
+		ServerErrorCount.WithLabelValues(server).Inc()
+		ext.Error.Set(span, true)
 		if err != nil {
-			NacosClientLogger.Error("error while request from " + url, err)
-		} else {
-			NacosClientLogger.Warn("error while request from " + url + ", code: " + strconv.Itoa(response.StatusCode))
+			logger.Error("error while request from " + url, err)
+			return "", err
 		}
-		return ""
+		logger.Warn("error while request from " + url + ", code: " + strconv.Itoa(response.StatusCode))
+		return "", NacosClientError{"request to " + server + " failed with code " + strconv.Itoa(response.StatusCode)}
 	}
 
 
 	b, err := ioutil.ReadAll(response.Body)
-	response.Body.Close()
 
 	if err != nil {
-		NacosClientLogger.Error("failed to get response body: " + url, err)
-		return ""
+		ServerErrorCount.WithLabelValues(server).Inc()
+		ext.Error.Set(span, true)
+		logger.Error("failed to get response body: " + url, err)
+		return "", err
 	}
 
 	bs := string(b)
-	return bs
-}
\ No newline at end of file
+	return bs, nil
+}
@@ -0,0 +1,157 @@
+/*
+ * Copyright 1999-2018 Alibaba Group Holding Ltd.
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *      http://www.apache.org/licenses/LICENSE-2.0
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package nacos
+
+import (
+	"encoding/json"
+	"fmt"
+	"math/rand"
+	"os"
+	"strings"
+
+	"github.com/cihub/seelog"
+	clog "github.com/coredns/coredns/plugin/pkg/log"
+)
+
+// log outputs
+const (
+	LogOutputFile   = "file"
+	LogOutputStderr = "stderr"
+	LogOutputClog   = "clog"
+)
+
+// log formats
+const (
+	LogFormatText = "text"
+	LogFormatJSON = "json"
+)
+
+var logFormats = map[string]string{
+	LogFormatText: `%Date(2006-01-02 15:04:05.000) %LEVEL %Msg%n`,
+	LogFormatJSON: `{"time":"%Date(2006-01-02T15:04:05.000Z07:00)","level":"%Level","msg":%JSONMsg}%n`,
+}
+
+// LoggerConfig is the configuration of a logger created by NewLogger.
+type LoggerConfig struct {
+	// one of the seelog levels, defaults to info
+	Level string
+	// one of the log outputs, defaults to LogOutputFile
+	Output string
+	// one of the log formats, defaults to LogFormatText
+	Format string
+	// dir of LogOutputFile, defaults to DefaultLogPath
+	Dir string
+}
+
+func init() {
+	seelog.RegisterReceiver("nacos-stderr", &stderrReceiver{})
+	seelog.RegisterReceiver("nacos-clog", &clogReceiver{})
+	seelog.RegisterCustomFormatter("JSONMsg", func(string) seelog.FormatterFunc {
+		return func(message string, level seelog.LogLevel, context seelog.LogContextInterface) interface{} {
+			b, _ := json.Marshal(message)
+			return string(b)
+		}
+	})
+}
+
+// NewLogger returns a logger for config.
+func NewLogger(config LoggerConfig) (seelog.LoggerInterface, error) {
+	if config.Level == "" {
+		config.Level = seelog.InfoStr
+	}
+	if _, ok := seelog.LogLevelFromString(config.Level); !ok {
+		return nil, fmt.Errorf("unknown log level '%s'", config.Level)
+	}
+
+	format, ok := logFormats[config.Format]
+	if config.Format == "" {
+		format, ok = logFormats[LogFormatText], true
+	}
+	if !ok {
+		return nil, fmt.Errorf("unknown log format '%s'", config.Format)
+	}
+
+	var output string
+	switch config.Output {
+	case "", LogOutputFile:
+		dir := config.Dir
+		if dir == "" {
+			initDir()
+			dir = DefaultLogPath
+		}
+		output = `<buffered size="1000" flushperiod="1000">
+                		<rollingfile type="size" filename="` + dir + `/nacos-go-client/nacos-go-client.log" maxsize="100000000" maxrolls="10"/>
+        			</buffered>`
+	case LogOutputStderr:
+		output = `<custom name="nacos-stderr"/>`
+	case LogOutputClog:
+		// clog adds time and level itself, a JSON message would be wrapped in its text
+		if config.Format == LogFormatJSON {
+			return nil, fmt.Errorf("log format '%s' conflicts with log output '%s'", LogFormatJSON, LogOutputClog)
+		}
+		format = `plugin/nacos: %Msg`
+		output = `<custom name="nacos-clog"/>`
+	default:
+		return nil, fmt.Errorf("unknown log output '%s'", config.Output)
+	}
+
+	return seelog.LoggerFromConfigAsString(`
+    		<seelog minlevel="` + config.Level + `">
+        		<outputs formatid="main">
+            		` + output + `
+    			</outputs>
+    			<formats>
+        			<format id="main" format="` + strings.Replace(format, `"`, `&quot;`, -1) + `"/>
+    			</formats>
+			</seelog>
+			`)
+}
+
+// stderrReceiver writes the log to stderr.
+type stderrReceiver struct{}
+
+func (r *stderrReceiver) ReceiveMessage(message string, level seelog.LogLevel, context seelog.LogContextInterface) error {
+	_, err := os.Stderr.WriteString(message)
+	return err
+}
+
+func (r *stderrReceiver) AfterParse(initArgs seelog.CustomReceiverInitArgs) error { return nil }
+func (r *stderrReceiver) Flush()                                                  {}
+func (r *stderrReceiver) Close() error                                            { return nil }
+
+// clogReceiver writes the log to the log of CoreDNS.
+type clogReceiver struct{}
+
+func (r *clogReceiver) ReceiveMessage(message string, level seelog.LogLevel, context seelog.LogContextInterface) error {
+	switch {
+	case level <= seelog.DebugLvl:
+		clog.Debug(message)
+	case level == seelog.InfoLvl:
+		clog.Info(message)
+	case level == seelog.WarnLvl:
+		clog.Warning(message)
+	default:
+		clog.Error(message)
+	}
+	return nil
+}
+
+func (r *clogReceiver) AfterParse(initArgs seelog.CustomReceiverInitArgs) error { return nil }
+func (r *clogReceiver) Flush()                                                  {}
+func (r *clogReceiver) Close() error                                            { return nil }
+
+// sampled reports whether a per query log should be written, see Nacos.LogSample.
+func (vs *Nacos) sampled() bool {
+	return vs.LogSample >= 1 || vs.LogSample > 0 && rand.Float64() < vs.LogSample
+}
@@ -0,0 +1,92 @@
+/*
+ * Copyright 1999-2018 Alibaba Group Holding Ltd.
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *      http://www.apache.org/licenses/LICENSE-2.0
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package nacos
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestNewLogger(t *testing.T) {
+	dir, err := ioutil.TempDir("", "nacos-log")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	logger, err := NewLogger(LoggerConfig{Level: "warn", Format: LogFormatJSON, Dir: dir})
+	if err != nil {
+		t.Fatal(err)
+	}
+	logger.Info("not logged")
+	logger.Warn(`dom "hello123" not found`)
+	logger.Flush()
+
+	b, err := ioutil.ReadFile(filepath.Join(dir, "nacos-go-client", "nacos-go-client.log"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	lines := strings.Split(strings.TrimSpace(string(b)), "\n")
+	if len(lines) != 1 {
+		t.Fatalf("expected only the warning to be logged, got %q", lines)
+	}
+
+	var entry struct{ Level, Msg string }
+	if err := json.Unmarshal([]byte(lines[0]), &entry); err != nil {
+		t.Fatalf("expected a JSON line, got %s: %v", lines[0], err)
+	}
+	if entry.Level != "Warn" || entry.Msg != `dom "hello123" not found` {
+		t.Fatalf("unexpected entry %+v", entry)
+	}
+
+	for _, config := range []LoggerConfig{{Level: "verbose"}, {Output: "syslog"}, {Format: "xml"}, {Output: LogOutputClog, Format: LogFormatJSON}} {
+		if _, err := NewLogger(config); err == nil {
+			t.Errorf("expected error for %+v", config)
+		}
+	}
+	if _, err := NewLogger(LoggerConfig{Output: LogOutputClog, Format: LogFormatText}); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestNacos_sampled(t *testing.T) {
+	vs := Nacos{LogSample: 0}
+	for i := 0; i < 100; i++ {
+		if vs.sampled() {
+			t.Fatal("expected no query to be sampled at rate 0")
+		}
+	}
+
+	vs.LogSample = 1
+	for i := 0; i < 100; i++ {
+		if !vs.sampled() {
+			t.Fatal("expected every query to be sampled at rate 1")
+		}
+	}
+
+	vs.LogSample = 0.5
+	n := 0
+	for i := 0; i < 10000; i++ {
+		if vs.sampled() {
+			n++
+		}
+	}
+	if n < 4000 || n > 6000 {
+		t.Fatalf("expected about half of the queries to be sampled, got %d of 10000", n)
+	}
+}
@@ -0,0 +1,130 @@
+/*
+ * Copyright 1999-2018 Alibaba Group Holding Ltd.
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *      http://www.apache.org/licenses/LICENSE-2.0
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package nacos
+
+import (
+	"github.com/coredns/coredns/plugin"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// sources of an answer
+const (
+	SourceManaged     = "managed"
+	SourceUpstream    = "upstream"
+	SourceFallthrough = "fallthrough"
+	SourceExcluded    = "excluded"
+	SourceReverse     = "reverse"
+	SourceUpdate      = "update"
+)
+
+// results of a push packet
+const (
+	PushReceived = "received"
+	PushAcked    = "acked"
+	PushRejected = "rejected"
+)
+
+// Metrics of the nacos plugin, they are registered with the prometheus plugin if it is enabled.
+var (
+	RequestCount = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: plugin.Namespace,
+		Subsystem: "nacos",
+		Name:      "requests_total",
+		Help:      "Counter of DNS requests by the source of the answer and rcode.",
+	}, []string{"source", "rcode"})
+
+	RequestDuration = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: plugin.Namespace,
+		Subsystem: "nacos",
+		Name:      "request_duration_seconds",
+		Buckets:   plugin.TimeBuckets,
+		Help:      "Histogram of the time it took to answer DNS requests by the source of the answer.",
+	}, []string{"source"})
+
+	CacheHits = prometheus.NewCounter(prometheus.CounterOpts{
+		Namespace: plugin.Namespace,
+		Subsystem: "nacos",
+		Name:      "cache_hits_total",
+		Help:      "Counter of service lookups answered from the domain cache.",
+	})
+
+	CacheMisses = prometheus.NewCounter(prometheus.CounterOpts{
+		Namespace: plugin.Namespace,
+		Subsystem: "nacos",
+		Name:      "cache_misses_total",
+		Help:      "Counter of service lookups that had to query nacos.",
+	})
+
+	CacheSize = prometheus.NewGauge(prometheus.GaugeOpts{
+		Namespace: plugin.Namespace,
+		Subsystem: "nacos",
+		Name:      "cache_size",
+		Help:      "Number of entries in the domain cache.",
+	})
+
+	ServerRequestCount = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: plugin.Namespace,
+		Subsystem: "nacos",
+		Name:      "server_requests_total",
+		Help:      "Counter of requests sent to nacos servers.",
+	}, []string{"server"})
+
+	ServerRequestDuration = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: plugin.Namespace,
+		Subsystem: "nacos",
+		Name:      "server_request_duration_seconds",
+		Buckets:   plugin.TimeBuckets,
+		Help:      "Histogram of the time requests to nacos servers took.",
+	}, []string{"server"})
+
+	ServerErrorCount = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: plugin.Namespace,
+		Subsystem: "nacos",
+		Name:      "server_errors_total",
+		Help:      "Counter of failed requests to nacos servers.",
+	}, []string{"server"})
+
+	PushCount = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: plugin.Namespace,
+		Subsystem: "nacos",
+		Name:      "push_packets_total",
+		Help:      "Counter of push packets from nacos servers by result.",
+	}, []string{"result"})
+
+	RefreshLag = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Namespace: plugin.Namespace,
+		Subsystem: "nacos",
+		Name:      "refresh_lag_seconds",
+		Help:      "Seconds since a service was last refreshed from nacos.",
+	}, []string{"service"})
+)
+
+func metricsCollectors() []prometheus.Collector {
+	return []prometheus.Collector{RequestCount, RequestDuration, CacheHits, CacheMisses, CacheSize,
+		ServerRequestCount, ServerRequestDuration, ServerErrorCount, PushCount, RefreshLag}
+}
+
+// registerMetrics registers the metrics with reg. The prometheus plugin has
+// a new registry after a reload, and every nacos block of a server registers
+// them, so metrics already registered with reg are skipped.
+func registerMetrics(reg prometheus.Registerer) error {
+	for _, collector := range metricsCollectors() {
+		if err := reg.Register(collector); err != nil {
+			if _, ok := err.(prometheus.AlreadyRegisteredError); !ok {
+				return err
+			}
+		}
+	}
+	return nil
+}
@@ -0,0 +1,108 @@
+/*
+ * Copyright 1999-2018 Alibaba Group Holding Ltd.
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *      http://www.apache.org/licenses/LICENSE-2.0
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package nacos
+
+import (
+	"context"
+	"testing"
+
+	"github.com/coredns/coredns/plugin/pkg/dnstest"
+	"github.com/coredns/coredns/plugin/test"
+	"github.com/miekg/dns"
+	"github.com/prometheus/client_golang/prometheus"
+	dto "github.com/prometheus/client_model/go"
+)
+
+func counterValue(c prometheus.Counter) float64 {
+	m := &dto.Metric{}
+	c.Write(m)
+	return m.GetCounter().GetValue()
+}
+
+func TestNacos_ServeDNSMetrics(t *testing.T) {
+	client := &NacosClient{domainMap: NewConcurrentMap(), indexMap: NewConcurrentMap(), cachePath: t.TempDir()}
+	client.domainMap.Set(GetCacheKey("hello123", "10.240.0.1"), Domain{
+		Name: "hello123", Instances: []Instance{{IP: "2.2.2.2", Port: 80, Weight: 1, Valid: true}}})
+	vs := Nacos{NacosClientImpl: client, DNSCache: NewConcurrentMap()}
+	vs.Next = test.NextHandler(dns.RcodeRefused, nil)
+	vs.Fall.SetZonesFromArgs(nil)
+
+	managed := RequestCount.WithLabelValues(SourceManaged, "NOERROR")
+	fell := RequestCount.WithLabelValues(SourceFallthrough, "REFUSED")
+	hits := counterValue(CacheHits)
+	before, beforeFall := counterValue(managed), counterValue(fell)
+
+	r := new(dns.Msg)
+	r.SetQuestion("hello123.", dns.TypeA)
+	vs.ServeDNS(context.TODO(), dnstest.NewRecorder(&test.ResponseWriter{}), r)
+	r.SetQuestion("www.example.org.", dns.TypeA)
+	vs.ServeDNS(context.TODO(), dnstest.NewRecorder(&test.ResponseWriter{}), r)
+
+	if v := counterValue(managed); v != before+1 {
+		t.Errorf("expected %v managed requests, got %v", before+1, v)
+	}
+	if v := counterValue(fell); v != beforeFall+1 {
+		t.Errorf("expected %v fallthrough requests, got %v", beforeFall+1, v)
+	}
+	if v := counterValue(CacheHits); v != hits+1 {
+		t.Errorf("expected %v cache hits, got %v", hits+1, v)
+	}
+}
+
+func TestRegisterMetrics(t *testing.T) {
+	reg := prometheus.NewRegistry()
+	if err := registerMetrics(reg); err != nil {
+		t.Fatal(err)
+	}
+	// another nacos block of the server
+	if err := registerMetrics(reg); err != nil {
+		t.Fatal(err)
+	}
+	// the registry of the prometheus plugin after a reload
+	if err := registerMetrics(prometheus.NewRegistry()); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestNacosClient_PurgeRefreshLag(t *testing.T) {
+	reg := prometheus.NewRegistry()
+	reg.MustRegister(RefreshLag)
+	lagged := func(dom string) bool {
+		families, _ := reg.Gather()
+		for _, family := range families {
+			for _, m := range family.GetMetric() {
+				for _, label := range m.GetLabel() {
+					if label.GetName() == "service" && label.GetValue() == dom {
+						return true
+					}
+				}
+			}
+		}
+		return false
+	}
+
+	client := &NacosClient{domainMap: NewConcurrentMap(), indexMap: NewConcurrentMap(), cachePath: t.TempDir()}
+	client.domainMap.Set(GetCacheKey("lag123", "10.0.0.1"), Domain{Name: "lag123"})
+	client.domainMap.Set(GetCacheKey("lag123", "10.0.0.2"), Domain{Name: "lag123"})
+	RefreshLag.WithLabelValues("lag123").Set(1)
+
+	client.Purge(GetCacheKey("lag123", "10.0.0.1"))
+	if !lagged("lag123") {
+		t.Fatal("expected the lag to stay while the dom is cached for another client IP")
+	}
+	client.Purge(GetCacheKey("lag123", "10.0.0.2"))
+	if lagged("lag123") {
+		t.Fatal("expected the lag of a purged dom to be deleted")
+	}
+}
@@ -16,21 +16,136 @@ package nacos
 import (
 	"github.com/miekg/dns"
 	"net"
-	"github.com/coredns/coredns/plugin/proxy"
 	"github.com/coredns/coredns/plugin"
+	"github.com/coredns/coredns/plugin/pkg/dnsutil"
+	"github.com/coredns/coredns/plugin/pkg/fall"
 	"time"
 	"strconv"
+	"strings"
 	"encoding/json"
 	"github.com/coredns/coredns/request"
 	"context"
+	"sync/atomic"
+	"github.com/cihub/seelog"
+	"github.com/opentracing/opentracing-go/ext"
 )
 
 type Nacos struct {
 	Next        plugin.Handler
 	Zones       []string
-	Proxy       proxy.Proxy
+	Upstream    *Forwarder
+	// upstreams for names under specific zones, the longest matching zone wins over Upstream
+	ZoneUpstreams map[string]*Forwarder
+	// names not registered in nacos under these zones are passed to the next plugin
+	Fall        fall.F
 	NacosClientImpl  *NacosClient
+	// registry the queries are answered from, defaults to NacosClientImpl
+	Discovery   ServiceDiscovery
 	DNSCache    ConcurrentMap
+	// TTL of the answers for doms registered in nacos and of cached upstream answers
+	TTL         uint32
+	// doms that are always forwarded to the upstream, even if registered in nacos
+	DNSDomains  map[string]string
+	// maps query names to nacos services and back
+	Mapper      NameMapper
+	// services that are exposed over DNS
+	Filter      ServiceFilter
+	// the plugin is unhealthy if nacos has not responded for this long
+	HealthWindow time.Duration
+	// optional HTTP API to inspect and control the caches
+	Admin       *Admin
+	// fraction of the queries whose resolution is logged
+	LogSample   float64
+	// registers the agent in nacos if set
+	Registrar   *Registrar
+	// defaults to the logger of NacosClientImpl
+	Logger      seelog.LoggerInterface
+	// keys DNS UPDATE messages must be signed with, by key name
+	TsigKeys    map[string]TsigKey
+	config      *Config
+	// set by OnRestart, the instance of the reloaded Corefile is running
+	restarting  bool
+	// set to 1 once prefetch is done or timed out, see Ready
+	prefetched  int32
+}
+
+// OnStartup starts the nacos client, the upstream health checks and the
+// prefetch of the configured doms.
+func (vs *Nacos) OnStartup() error {
+	if r, ok := vs.Registry().(runner); ok {
+		if err := r.Start(context.Background()); err != nil {
+			return err
+		}
+	}
+
+	for _, f := range vs.forwarders() {
+		f.StartHealthCheck()
+	}
+
+	if vs.Registrar != nil {
+		vs.Registrar.Metadata["pushPort"] = strconv.Itoa(vs.NacosClientImpl.udpServer.port)
+		vs.Registrar.Start()
+	}
+
+	if vs.config != nil && vs.config.Prefetch {
+		vs.NacosClientImpl.Prefetch(vs.config.PrefetchDoms, vs.config.PrefetchTimeout)
+		atomic.StoreInt32(&vs.prefetched, 1)
+	}
+
+	if vs.Admin != nil {
+		if err := vs.Admin.Start(); err != nil {
+			// the Corefile is not loaded, nothing else stops what was started
+			vs.OnShutdown()
+			return err
+		}
+	}
+	return nil
+}
+
+// OnRestart is called before the Corefile is reloaded. The instance of the
+// new Corefile is started before this one is shut down, and takes over what
+// is shared, e.g. the cache dir and the registration in nacos. If the reload fails this instance keeps
+// running and leaves them alone on shutdown as well.
+func (vs *Nacos) OnRestart() error {
+	vs.restarting = true
+	return nil
+}
+
+// OnShutdown stops everything started by OnStartup, it is also called
+// for the old instance when the Corefile is reloaded.
+func (vs *Nacos) OnShutdown() error {
+	if vs.Admin != nil {
+		vs.Admin.Stop()
+	}
+	if vs.Registrar != nil {
+		// the new instance registered the same ip:port already, it expires
+		// in nacos if the reloaded Corefile does not register it any more
+		if err := vs.Registrar.stop(!vs.restarting); err != nil {
+			vs.logger().Warn("failed to deregister: ", err)
+		}
+	}
+	for _, f := range vs.forwarders() {
+		f.Stop()
+	}
+	if client, ok := vs.Registry().(*NacosClient); ok && vs.restarting {
+		// the new client loaded the cache dir already, do not overwrite it with older doms
+		return client.stop(false)
+	}
+	if r, ok := vs.Registry().(runner); ok {
+		return r.Stop()
+	}
+	return nil
+}
+
+func (vs *Nacos) forwarders() []*Forwarder {
+	var forwarders []*Forwarder
+	if vs.Upstream != nil {
+		forwarders = append(forwarders, vs.Upstream)
+	}
+	for _, f := range vs.ZoneUpstreams {
+		forwarders = append(forwarders, f)
+	}
+	return forwarders
 }
 
 func (vs *Nacos) String() string {
@@ -45,76 +160,176 @@ func (vs *Nacos) String() string {
 
 // Lookup implements the ServiceBackend interface.
 func (e *Nacos) Lookup(state request.Request, name string, typ uint16) (*dns.Msg, error) {
-	key := name + strconv.Itoa(state.Family())
+	return e.LookupContext(context.Background(), state, name, typ)
+}
+
+// LookupContext is Lookup as part of the trace in ctx.
+func (e *Nacos) LookupContext(ctx context.Context, state request.Request, name string, typ uint16) (*dns.Msg, error) {
+	key := name + strconv.Itoa(state.Family()) + dns.TypeToString[typ]
 	msg, ok := e.DNSCache.Get(key)
+	upstream := e.upstreamFor(name)
+	if upstream == nil {
+		return nil, NacosClientError{"no upstream configured for " + name}
+	}
 
-	NacosClientLogger.Info("lookup " + name + " from upstream ")
+	sampled := e.sampled()
+	if sampled {
+		e.logger().Info("lookup " + name + " from upstream ")
+	}
 	if ok {
 		dnsCache := msg.(DnsCache)
-		if !dnsCache.Updated() {
-			msg1, err := e.Proxy.Lookup(state, name, typ)
+		if !dnsCache.UpdatedAt(e.clock().Now()) {
+			msg1, err := e.forward(ctx, upstream, state, name, typ)
 			if err == nil {
-				if len(msg1.Answer) > 0 {
+				if cacheable(msg1) {
 					dnsCache.Msg = msg1
-					dnsCache.LastUpdateMills = time.Now().UnixNano() / 1000000
+					dnsCache.LastUpdateMills = millis(e.clock().Now())
 					e.DNSCache.Set(key, dnsCache)
 				}
 			} else {
-				NacosClientLogger.Warn("error while lookup dom: ", err)
+				e.logger().Warn("error while lookup dom: ", err)
 			}
 		}
-		bs, err := json.Marshal(dnsCache.Msg)
-
-		if err == nil {
-			NacosClientLogger.Info("Forward " + name + " -> " + string(bs))
+		if sampled {
+			if bs, err := json.Marshal(dnsCache.Msg); err == nil {
+				e.logger().Info("Forward " + name + " -> " + string(bs))
+			}
 		}
 
 		return dnsCache.Msg, nil
 	} else {
-		msg1, err := e.Proxy.Lookup(state, name, typ)
+		msg1, err := e.forward(ctx, upstream, state, name, typ)
 		if err == nil {
-			dnsCache := DnsCache{Msg: msg1, LastUpdateMills: time.Now().UnixNano() / 1000000}
-			e.DNSCache.Set(name, dnsCache)
+			if cacheable(msg1) {
+				dnsCache := DnsCache{Msg: msg1, LastUpdateMills: millis(e.clock().Now()), TTL: e.TTL}
+				e.DNSCache.Set(key, dnsCache)
+			}
 		} else {
-			NacosClientLogger.Warn("error while lookup dom: ", err)
+			e.logger().Warn("error while lookup dom: ", err)
 		}
 
-		bs, err := json.Marshal(msg1)
-
-		if err == nil {
-			NacosClientLogger.Info("Forward " + name + " -> " + string(bs))
+		if sampled && err == nil {
+			if bs, err := json.Marshal(msg1); err == nil {
+				e.logger().Info("Forward " + name + " -> " + string(bs))
+			}
 		}
 
 		return msg1, err
 	}
 }
 
+// cacheable reports whether an upstream answer is cached. Errors and
+// negative answers are not, they would hide a name for the whole TTL.
+func cacheable(msg *dns.Msg) bool {
+	return msg.Rcode == dns.RcodeSuccess && len(msg.Answer) > 0
+}
+
+func (e *Nacos) forward(ctx context.Context, upstream *Forwarder, state request.Request, name string, typ uint16) (*dns.Msg, error) {
+	span, _ := startSpan(ctx, SpanForward)
+	defer span.Finish()
+	span.SetTag("name", name)
+
+	msg, err := upstream.Lookup(state, name, typ)
+	if err != nil {
+		ext.Error.Set(span, true)
+		span.SetTag("error.message", err.Error())
+	}
+	return msg, err
+}
+
+// upstreamFor returns the forwarder of the longest zone in ZoneUpstreams
+// that name belongs to, or the default Upstream if there is none.
+func (e *Nacos) upstreamFor(name string) *Forwarder {
+	name = strings.ToLower(name)
+	match := ""
+	for zone := range e.ZoneUpstreams {
+		if dns.IsSubDomain(zone, name) && len(zone) > len(match) {
+			match = zone
+		}
+	}
+
+	if match == "" {
+		return e.Upstream
+	}
+	return e.ZoneUpstreams[match]
+}
+
 func (vs *Nacos) managed(dom, clientIP string) bool {
-	if _, ok := DNSDomains[dom]; ok {
+	return vs.known(dom, clientIP) && vs.Filter.Allowed(ParseServiceKey(dom))
+}
+
+// hidden reports whether dom is in nacos but not exposed because of Filter.
+func (vs *Nacos) hidden(dom, clientIP string) bool {
+	return vs.known(dom, clientIP) && !vs.Filter.Allowed(ParseServiceKey(dom))
+}
+
+func (vs *Nacos) known(dom, clientIP string) bool {
+	if _, ok := vs.DNSDomains[dom]; ok {
 		return false
 	}
 
-	defer AllDoms.DLock.RUnlock()
-
-	AllDoms.DLock.RLock()
-	_, ok1 := AllDoms.Data[dom]
+	return vs.Registry().Managed(dom, clientIP)
+}
 
-	cacheKey := GetCacheKey(dom, clientIP)
+// Registry returns the registry the queries are answered from. Other
+// plugins can subscribe to the changes of services with it.
+func (vs *Nacos) Registry() ServiceDiscovery {
+	if vs.Discovery != nil {
+		return vs.Discovery
+	}
+	return vs.NacosClientImpl
+}
 
-	_, inCache := vs.NacosClientImpl.GetDomainCache().Get(cacheKey)
+func (vs *Nacos) logger() seelog.LoggerInterface {
+	if vs.Logger != nil {
+		return vs.Logger
+	}
+	if vs.NacosClientImpl == nil {
+		return NacosClientLogger
+	}
+	return vs.NacosClientImpl.Logger()
+}
 
-	return ok1 || inCache
+// clock returns the clock of the nacos client, upstream answers are cached by it too.
+func (vs *Nacos) clock() Clock {
+	if vs.NacosClientImpl == nil {
+		return SystemClock
+	}
+	return vs.NacosClientImpl.Clock()
 }
 
 func (vs *Nacos) getRecordBySession(dom, clientIP string) Instance {
-	host := *vs.NacosClientImpl.SrvInstance(dom, clientIP)
-	return host
+	host := vs.Registry().SrvInstanceContext(context.Background(), dom, clientIP)
+	if host == nil {
+		return Instance{}
+	}
+	return *host
 
 }
 
 func (vs *Nacos) ServeDNS(ctx context.Context, w dns.ResponseWriter, r *dns.Msg) (int, error) {
+	start := time.Now()
+	span, ctx := startSpan(ctx, SpanServeDNS)
+	defer span.Finish()
+
+	source, rcode, err := vs.serveDNS(ctx, w, r)
+	span.SetTag("source", source)
+	span.SetTag("rcode", dns.RcodeToString[rcode])
+
+	RequestCount.WithLabelValues(source, dns.RcodeToString[rcode]).Inc()
+	RequestDuration.WithLabelValues(source).Observe(time.Since(start).Seconds())
+	return rcode, err
+}
+
+// serveDNS is ServeDNS, it also returns the source of the answer for the metrics.
+func (vs *Nacos) serveDNS(ctx context.Context, w dns.ResponseWriter, r *dns.Msg) (string, int, error) {
 	state := request.Request{W: w, Req: r}
 
+	if r.Opcode == dns.OpcodeUpdate {
+		rcode, err := vs.serveUpdate(ctx, state)
+		return SourceUpdate, rcode, err
+	}
+
 	name := state.QName()
 
 	m := new(dns.Msg)
@@ -124,14 +339,56 @@ func (vs *Nacos) ServeDNS(ctx context.Context, w dns.ResponseWriter, r *dns.Msg)
 		clientIP = LocalIP()
 	}
 
-	if !vs.managed(name[:len(name)-1], clientIP) {
-		dnsMsg, _ := vs.Lookup(state, name, state.QType())
+	if state.QType() == dns.TypePTR && vs.Mapper.HasServiceRules() {
+		if answer := vs.reverse(state); len(answer) > 0 {
+			m.Answer = answer
+			rcode, err := vs.reply(state, m, dns.RcodeSuccess)
+			return SourceReverse, rcode, err
+		}
+	}
+
+	service, _ := vs.Mapper.ToService(name[:len(name)-1])
+	dom := service.Key()
+	source := SourceManaged
+	// the rcode of the upstream is passed on, e.g. NXDOMAIN
+	upstreamRcode := dns.RcodeSuccess
+
+	managedSpan, _ := startSpan(ctx, SpanManaged)
+	managed := vs.managed(dom, clientIP)
+	managedSpan.SetTag("managed", managed)
+	managedSpan.Finish()
+
+	if !managed {
+		if vs.Fall.Through(name) {
+			rcode, err := plugin.NextOrFailure(vs.Name(), vs.Next, ctx, w, r)
+			return SourceFallthrough, rcode, err
+		}
+
+		// excluded services are answered as if they did not exist
+		if vs.hidden(dom, clientIP) {
+			rcode, err := vs.reply(state, m, dns.RcodeNameError)
+			return SourceExcluded, rcode, err
+		}
+
+		dnsMsg, err := vs.LookupContext(ctx, state, name, state.QType())
+		if err != nil {
+			return SourceUpstream, dns.RcodeServerFailure, err
+		}
 		m.Answer = dnsMsg.Answer
+		m.Ns = dnsMsg.Ns
 		m.Extra = dnsMsg.Extra
+		upstreamRcode = dnsMsg.Rcode
+		source = SourceUpstream
 
 	} else {
 		hosts := make([]Instance, 0)
-		host := vs.NacosClientImpl.SrvInstance(name[:len(name)-1], clientIP)
+		host := vs.Registry().SrvInstanceContext(ctx, dom, clientIP)
+		if host == nil {
+			// registered, but nacos has no valid instance of it
+			vs.logger().Warn("no valid instance of " + dom)
+			rcode, err := vs.reply(state, m, dns.RcodeServerFailure)
+			return source, rcode, err
+		}
 		hosts = append(hosts, *host)
 
 		answer := make([]dns.RR, 0)
@@ -142,19 +399,22 @@ func (vs *Nacos) ServeDNS(ctx context.Context, w dns.ResponseWriter, r *dns.Msg)
 			switch state.Family() {
 			case 1:
 				rr = new(dns.A)
-				rr.(*dns.A).Hdr = dns.RR_Header{Name: state.QName(), Rrtype: dns.TypeA, Class: state.QClass(), Ttl: DNSTTL}
+				rr.(*dns.A).Hdr = dns.RR_Header{Name: state.QName(), Rrtype: dns.TypeA, Class: state.QClass(), Ttl: vs.TTL}
 				rr.(*dns.A).A = net.ParseIP(host.IP).To4()
 			case 2:
 				rr = new(dns.AAAA)
-				rr.(*dns.AAAA).Hdr = dns.RR_Header{Name: state.QName(), Rrtype: dns.TypeAAAA, Class: state.QClass(), Ttl: DNSTTL}
+				rr.(*dns.AAAA).Hdr = dns.RR_Header{Name: state.QName(), Rrtype: dns.TypeAAAA, Class: state.QClass(), Ttl: vs.TTL}
 				rr.(*dns.AAAA).AAAA = net.ParseIP(host.IP)
 			}
 
 			srv := new(dns.SRV)
-			srv.Hdr = dns.RR_Header{Name: "_" + state.Proto() + "." + state.QName(), Rrtype: dns.TypeSRV, Class: state.QClass(), Ttl: DNSTTL}
+			srv.Hdr = dns.RR_Header{Name: "_" + state.Proto() + "." + state.QName(), Rrtype: dns.TypeSRV, Class: state.QClass(), Ttl: vs.TTL}
 			port := host.Port
 			srv.Port = uint16(port)
 			srv.Target = "."
+			if target, ok := vs.Mapper.ToName(service); ok {
+				srv.Target = dns.Fqdn(target)
+			}
 
 			extra = append(extra, srv)
 			answer = append(answer, rr)
@@ -162,17 +422,52 @@ func (vs *Nacos) ServeDNS(ctx context.Context, w dns.ResponseWriter, r *dns.Msg)
 
 		m.Answer = answer
 		m.Extra = extra
-		result, _ := json.Marshal(m.Answer)
-		NacosClientLogger.Info("[RESOLVE]",  " [" + name[:len(name)-1] + "]  result: " + string(result) + ", clientIP: " + clientIP)
+		if vs.sampled() {
+			result, _ := json.Marshal(m.Answer)
+			vs.logger().Info("[RESOLVE]",  " [" + dom + "]  result: " + string(result) + ", clientIP: " + clientIP)
+		}
 	}
 
-	m.SetReply(r)
+	rcode, err := vs.reply(state, m, upstreamRcode)
+	return source, rcode, err
+}
+
+func (vs *Nacos) reply(state request.Request, m *dns.Msg, rcode int) (int, error) {
+	m.SetRcode(state.Req, rcode)
 	m.Authoritative, m.RecursionAvailable, m.Compress = true, true, true
 
 	state.SizeAndDo(m)
 	m = state.Scrub(m)
-	w.WriteMsg(m)
-	return dns.RcodeSuccess, nil
+	state.W.WriteMsg(m)
+	return rcode, nil
+}
+
+// reverse answers a PTR query with the names of the cached services that
+// have an instance on the queried address.
+func (vs *Nacos) reverse(state request.Request) []dns.RR {
+	addr := dnsutil.ExtractAddressFromReverse(state.QName())
+	if addr == "" {
+		return nil
+	}
+
+	answer := make([]dns.RR, 0)
+	for _, dom := range vs.Registry().DomsByIP(addr) {
+		service := ParseServiceKey(dom)
+		if !vs.Filter.Allowed(service) {
+			continue
+		}
+
+		name, ok := vs.Mapper.ToName(service)
+		if !ok {
+			continue
+		}
+
+		ptr := new(dns.PTR)
+		ptr.Hdr = dns.RR_Header{Name: state.QName(), Rrtype: dns.TypePTR, Class: state.QClass(), Ttl: vs.TTL}
+		ptr.Ptr = dns.Fqdn(name)
+		answer = append(answer, ptr)
+	}
+	return answer
 }
 
 func (vs *Nacos) Name() string { return "nacos" }
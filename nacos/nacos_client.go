@@ -14,19 +14,21 @@
 package nacos
 
 import (
+	"context"
 	"encoding/json"
 	"fmt"
 	"io/ioutil"
 	"math/rand"
 	"os"
 	"path/filepath"
-	"reflect"
+	"sort"
 	"strconv"
 	"strings"
 	"sync"
 	"time"
 
 	"github.com/cihub/seelog"
+	"github.com/nacos-group/nacos-coredns-plugin/nacos/nacoscache"
 )
 
 var (
@@ -35,7 +37,8 @@ var (
 )
 
 func init() {
-	initLog()
+	// log to stderr until a client is created, importing the package creates no files
+	NacosClientLogger, _ = NewLogger(LoggerConfig{Output: LogOutputStderr})
 }
 
 type NacosClient struct {
@@ -43,6 +46,38 @@ type NacosClient struct {
 	udpServer     UDPServer
 	serverManager ServerManager
 	serverPort    int
+	failover      *FailoverReactor
+	allDoms       AllDomsMap
+	indexMap      ConcurrentMap
+	cachePath     string
+	logger        seelog.LoggerInterface
+	cancel        context.CancelFunc
+	wg            sync.WaitGroup
+	// done once Stop is called, see Start
+	ctx context.Context
+	// entries of domainMap counted in CacheSize
+	cacheSize int
+	// set to 1 once the doms are known, see Synced
+	synced int32
+	// millis of the last response from nacos, see LastContact
+	lastContact int64
+	// defaults to SystemClock, see Clock
+	clock Clock
+	// listeners of the changes of the cached doms, see Subscribe
+	listeners listeners
+}
+
+// ClientConfig is the configuration of a NacosClient. Every client has its
+// own cache dir and logger, so several clients can run in one process.
+type ClientConfig struct {
+	Servers    []string
+	ServerPort int
+	// defaults to the dir of Servers and ServerPort in DefaultCachePath
+	CachePath string
+	// defaults to NacosClientLogger
+	Logger seelog.LoggerInterface
+	// defaults to SystemClock
+	Clock Clock
 }
 
 type NacosClientError struct {
@@ -53,6 +88,8 @@ func (err NacosClientError) Error() string {
 	return err.Msg
 }
 
+// Inited is set once any client is synced.
+// Deprecated: use NacosClient.Synced or Nacos.Ready.
 var Inited = false
 
 func exists(path string) (bool, error) {
@@ -72,15 +109,15 @@ func initDir() {
 		os.Exit(1)
 	}
 
-	if LogPath == "" {
-		LogPath = dir + string(os.PathSeparator) + "logs"
+	if DefaultLogPath == "" {
+		DefaultLogPath = dir + string(os.PathSeparator) + "logs"
 	}
 
-	if CachePath == "" {
-		CachePath = dir + string(os.PathSeparator) + "nacos-go-client-cache"
+	if DefaultCachePath == "" {
+		DefaultCachePath = dir + string(os.PathSeparator) + "nacos-go-client-cache"
 	}
 
-	mkdirIfNecessary(CachePath)
+	mkdirIfNecessary(DefaultCachePath)
 }
 
 func mkdirIfNecessary(path string) {
@@ -92,34 +129,26 @@ func mkdirIfNecessary(path string) {
 	}
 }
 
+var fileLogInited bool
+
+// initLog replaces the stderr logger of init with the default file logger.
 func initLog() {
-	if NacosClientLogger != nil {
+	if fileLogInited {
 		return
 	}
+	fileLogInited = true
 
 	initDir()
 	var err error
 	var nacosLogger seelog.LoggerInterface
 	if LogConfig == "" || !Exist(LogConfig) {
-		home := Home()
-		LogConfig = home
-		nacosLogger, err = seelog.LoggerFromConfigAsString(`
-    		<seelog minlevel="info" maxlevel="error">
-        		<outputs formatid="main">
-            		<buffered size="1000" flushperiod="1000">
-                		<rollingfile type="size" filename="` + home + `/logs/nacos-go-client/nacos-go-client.log" maxsize="100000000" maxrolls="10"/>
-        			</buffered>
-    			</outputs>
-    			<formats>
-        			<format id="main" format="%Date(2006-01-02 15:04:05.000) %LEVEL %Msg %n"/>
-    			</formats>
-			</seelog>
-			`)
+		LogConfig = DefaultLogPath
+		nacosLogger, err = NewFileLogger(DefaultLogPath)
 	} else {
 		nacosLogger, err = seelog.LoggerFromConfigAsFile(LogConfig)
 	}
 
-	fmt.Println("log directory: " + LogConfig + "/logs/nacos-go-client/")
+	fmt.Println("log directory: " + LogConfig + "/nacos-go-client/")
 
 	if err != nil {
 		fmt.Println("Failed to init log, ", err)
@@ -128,9 +157,47 @@ func initLog() {
 	}
 }
 
-func (nacosClient *NacosClient) asyncGetAllDomNAmes() {
+// NewFileLogger returns a logger writing to a rolling file in logDir/nacos-go-client.
+func NewFileLogger(logDir string) (seelog.LoggerInterface, error) {
+	return NewLogger(LoggerConfig{Dir: logDir})
+}
+
+// Logger returns the logger of this client.
+func (vc *NacosClient) Logger() seelog.LoggerInterface {
+	if vc.logger == nil {
+		return NacosClientLogger
+	}
+	return vc.logger
+}
+
+// Clock returns the clock the cache of this client expires by.
+func (vc *NacosClient) Clock() Clock {
+	if vc.clock == nil {
+		return SystemClock
+	}
+	return vc.clock
+}
+
+// now returns the time of the clock of this client in milliseconds.
+func (vc *NacosClient) now() int64 {
+	return millis(vc.Clock().Now())
+}
+
+func (nacosClient *NacosClient) asyncGetAllDomNAmes(ctx context.Context) {
 	for {
-		time.Sleep(time.Duration(AllDoms.CacheSeconds) * time.Second)
+		nacosClient.allDoms.DLock.RLock()
+		cacheSeconds := nacosClient.allDoms.CacheSeconds
+		nacosClient.allDoms.DLock.RUnlock()
+
+		if cacheSeconds <= 0 {
+			cacheSeconds = DefaultAllDomsCacheSeconds
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-nacosClient.Clock().After(time.Duration(cacheSeconds) * time.Second):
+		}
 		nacosClient.getAllDomNames()
 	}
 }
@@ -145,11 +212,12 @@ func (nacosClient *NacosClient) GetUdpServer() (us UDPServer) {
 
 func (nacosClient *NacosClient) getAllDomNames() {
 	ip := nacosClient.serverManager.NextServer()
-	s := Get("http://"+ip+":"+strconv.Itoa(nacosClient.serverPort)+"/nacos/v1/ns/api/allDomNames", nil)
+	s, _ := request(context.Background(), nacosClient.Logger(), "GET", "http://"+ip+":"+strconv.Itoa(nacosClient.serverPort)+"/nacos/v1/ns/api/allDomNames", nacosClient.params())
 
 	if s == "" {
 		return
 	}
+	nacosClient.markContact()
 
 	var allName AllDomNames
 
@@ -160,118 +228,322 @@ func (nacosClient *NacosClient) getAllDomNames() {
 		var newAllName NewAllDomNames
 		err = json.Unmarshal([]byte(s), &newAllName)
 		if err != nil {
-			NacosClientLogger.Error("failed to unmarshal json: "+s, err)
+			nacosClient.Logger().Error("failed to unmarshal json: "+s, err)
 			return
 		}
 		var doms []string
-		for _, domMap := range newAllName.Doms {
+		for namespace, domMap := range newAllName.Doms {
 			for _, dom := range domMap {
-				doms = append(doms, dom)
+				// the doms of other namespaces are only queried with their namespace
+				if namespace == "" || namespace == DefaultNamespace {
+					doms = append(doms, dom)
+				}
+				if namespace != "" {
+					doms = append(doms, namespace+NAMESPACE_SEPERATOR+dom)
+				}
 			}
 		}
 		allName.Doms = doms
 		allName.CacheMillis = newAllName.CacheMillis
 	}
 
+	nacosClient.markSynced()
+
 	tmpMap := make(map[string]bool)
 
 	for _, dom := range allName.Doms {
 		tmpMap[dom] = true
 	}
 
-	AllDoms.DLock.Lock()
-	AllDoms.Data = tmpMap
+	nacosClient.allDoms.DLock.Lock()
+	nacosClient.allDoms.Data = tmpMap
 
-	if allName.CacheMillis < 30*1000 {
-		AllDoms.CacheSeconds = 30
+	if allName.CacheMillis < DefaultAllDomsCacheSeconds*1000 {
+		nacosClient.allDoms.CacheSeconds = DefaultAllDomsCacheSeconds
 	} else {
-		AllDoms.CacheSeconds = allName.CacheMillis / 1000
+		nacosClient.allDoms.CacheSeconds = allName.CacheMillis / 1000
 	}
 
-	AllDoms.DLock.Unlock()
+	nacosClient.allDoms.DLock.Unlock()
+}
+
+// get is the nacoscache.Getter of the client.
+func (vc *NacosClient) get(ctx context.Context, url string, params map[string]string) (string, error) {
+	return request(ctx, vc.Logger(), "GET", url, params)
+}
+
+// params returns the query parameters sent with every request to nacos.
+func (nacosClient *NacosClient) params() map[string]string {
+	params := make(map[string]string)
+	port := nacosClient.udpServer.port
+	if port == 0 {
+		port = -1
+	}
+	params["udpPort"] = strconv.Itoa(port)
+	return params
 }
 
 func (nacosClient *NacosClient) SetServers(servers []string) {
 	nacosClient.serverManager.SetServers(servers)
 }
 
+// Managed implements ServiceDiscovery, dom is managed if it is registered
+// in nacos, cached for clientIP or in the failover data.
+func (vc *NacosClient) Managed(dom, clientIP string) bool {
+	_, inCache := vc.domainMap.Get(GetCacheKey(dom, clientIP))
+	_, inFailover := vc.FailoverDomain(dom)
+
+	return vc.Registered(dom) || inCache || inFailover
+}
+
+// Subscribe implements ServiceDiscovery. The listener is called when a
+// refresh or a push changes the instances cached for any client. Unless
+// dom is cached for the local IP already, the client fetches it for the
+// local IP once it is registered in nacos, so the listener is called with
+// its instances once the client is started, and nacos pushes its changes.
+// Subscribing does not make dom managed.
+func (vc *NacosClient) Subscribe(dom string, listener ServiceListener) int {
+	return vc.listeners.subscribe(dom, listener)
+}
+
+// Unsubscribe implements ServiceDiscovery.
+func (vc *NacosClient) Unsubscribe(dom string, id int) {
+	vc.listeners.unsubscribe(dom, id)
+}
+
 func (vc *NacosClient) Registered(dom string) bool {
-	defer AllDoms.DLock.RUnlock()
-	AllDoms.DLock.RLock()
-	_, ok1 := AllDoms.Data[dom]
+	defer vc.allDoms.DLock.RUnlock()
+	vc.allDoms.DLock.RLock()
+	_, ok := vc.allDoms.Data[dom]
+	return ok
+}
+
+// AllDomNames returns the names of all doms registered in nacos.
+func (vc *NacosClient) AllDomNames() []string {
+	vc.allDoms.DLock.RLock()
+	defer vc.allDoms.DLock.RUnlock()
 
-	return ok1
+	doms := make([]string, 0, len(vc.allDoms.Data))
+	for dom := range vc.allDoms.Data {
+		doms = append(doms, dom)
+	}
+	return doms
+}
+
+// CachePath returns the dir the doms of this client are cached in, it is
+// empty for a client not created by NewNacosClientWithConfig.
+func (vc *NacosClient) CachePath() string {
+	return vc.cachePath
+}
+
+// cacheFile returns the file a cache key is cached in. Keys come from query
+// names and may contain anything, keys that are no plain file name are refused
+// so that they cannot point outside of the cache dir. Without a cache dir
+// every key is refused, the files would end up in the working dir.
+func (vc *NacosClient) cacheFile(key string) (string, error) {
+	if vc.cachePath == "" {
+		return "", NacosClientError{"no cache dir"}
+	}
+	if key == "" || key == "." || key == ".." || strings.ContainsAny(key, "/\\\x00") {
+		return "", fmt.Errorf("invalid cache key %q", key)
+	}
+	return filepath.Join(vc.CachePath(), key), nil
 }
 
 func (vc *NacosClient) loadCache() {
-	files, err := ioutil.ReadDir(CachePath)
+	files, err := ioutil.ReadDir(vc.CachePath())
 	if err != nil {
-		NacosClientLogger.Critical(err)
+		vc.Logger().Critical(err)
 	}
 
 	for _, f := range files {
-		fileName := CachePath + string(os.PathSeparator) + f.Name()
+		fileName := vc.CachePath() + string(os.PathSeparator) + f.Name()
 		b, err := ioutil.ReadFile(fileName)
 		if err != nil {
-			NacosClientLogger.Error("failed to read cache file: "+fileName, err)
+			vc.Logger().Error("failed to read cache file: "+fileName, err)
 		}
 
 		s := string(b)
-		domain, err1 := ProcessDomainString(s)
+		domain, err1 := processDomainString(s, vc.Logger())
 
 		if err1 != nil {
 			continue
 		}
 
 		vc.domainMap.Set(f.Name(), domain)
+		vc.markSynced()
 	}
 
-	NacosClientLogger.Info("finish loading cache, total: " + strconv.Itoa(len(files)))
+	vc.Logger().Info("finish loading cache, total: " + strconv.Itoa(len(files)))
 }
 
+// ProcessDomainString parses a dom as returned by nacos, it logs to NacosClientLogger.
 func ProcessDomainString(s string) (Domain, error) {
-	var domain Domain
-	err1 := json.Unmarshal([]byte(s), &domain)
+	return processDomainString(s, NacosClientLogger)
+}
+
+// processDomainString is ProcessDomainString logging to logger.
+func processDomainString(s string, logger seelog.LoggerInterface) (Domain, error) {
+	domain, err1 := nacoscache.Parse(s)
 
+	if err1 == nacoscache.ErrNoInstances {
+		logger.Warn("get empty ip list, ignore it, dom: " + domain.Name)
+		return domain, err1
+	}
 	if err1 != nil {
-		NacosClientLogger.Error("failed to unmarshal json string: "+s, err1)
+		logger.Error("failed to unmarshal json string: "+s, err1)
 		return Domain{}, err1
 	}
 
-	if len(domain.Instances) == 0 {
-		NacosClientLogger.Warn("get empty ip list, ignore it, dom: " + domain.Name)
-		return domain, NacosClientError{"empty ip list"}
-	}
-
-	NacosClientLogger.Info("domain "+domain.Name+" is updated, current ips: ", domain.getInstances())
+	logger.Info("domain "+domain.Name+" is updated, current ips: ", domain.Instances)
 
 	return domain, nil
 }
 
+// NewNacosClient creates a client and starts it right away.
 func NewNacosClient(servers []string, serverPort int) *NacosClient {
+	vc := NewNacosClientWithConfig(ClientConfig{Servers: servers, ServerPort: serverPort})
+	if err := vc.Start(context.Background()); err != nil {
+		vc.Logger().Critical(err.Error())
+		os.Exit(1)
+	}
+	return vc
+}
+
+// NewNacosClientWithConfig creates a client with the doms cached on disk.
+// Nothing is fetched from nacos until Start is called.
+func NewNacosClientWithConfig(config ClientConfig) *NacosClient {
 	fmt.Println("init nacos client.")
-	initLog()
-	vc := NacosClient{NewConcurrentMap(), UDPServer{}, ServerManager{}, serverPort}
+	if config.Logger == nil {
+		initLog()
+	}
+	initDir()
+
+	if config.CachePath == "" {
+		config.CachePath = filepath.Join(DefaultCachePath, nacoscache.ClusterDir(config.Servers, config.ServerPort))
+	}
+	mkdirIfNecessary(config.CachePath)
+
+	vc := NacosClient{
+		domainMap:  NewConcurrentMap(),
+		serverPort: config.ServerPort,
+		indexMap:   NewConcurrentMap(),
+		cachePath:  config.CachePath,
+		logger:     config.Logger,
+		clock:      config.Clock,
+	}
+	vc.serverManager.SetClock(vc.Clock())
+	vc.serverManager.logger = config.Logger
+	vc.allDoms.Data = make(map[string]bool)
 	vc.loadCache()
 	vc.udpServer.vipClient = &vc
-	vc.SetServers(servers)
+	vc.SetServers(config.Servers)
+
+	vc.Logger().Info("cache-path: " + vc.CachePath())
+	return &vc
+}
+
+// Start starts the push listener and the loops refreshing doms from nacos.
+// They run until ctx is done or Stop is called.
+func (vc *NacosClient) Start(ctx context.Context) error {
+	ctx, vc.cancel = context.WithCancel(ctx)
+	vc.ctx = ctx
+	vc.markContact()
 
 	if EnableReceivePush {
-		go vc.udpServer.StartServer()
+		if err := vc.udpServer.Listen(); err != nil {
+			vc.cancel()
+			return err
+		}
+		vc.goWithWait(vc.udpServer.Serve)
 	}
 
-	AllDoms = AllDomsMap{}
-	AllDoms.Data = make(map[string]bool)
-	AllDoms.DLock = sync.RWMutex{}
-
 	vc.getAllDomNames()
 
-	go vc.asyncGetAllDomNAmes()
+	vc.goWithWait(func() { vc.asyncGetAllDomNAmes(ctx) })
 
-	go vc.asyncUpdateDomain()
+	vc.goWithWait(func() { vc.asyncUpdateDomain(ctx) })
 
-	NacosClientLogger.Info("cache-path: " + CachePath)
-	return &vc
+	if vc.failover != nil {
+		vc.goWithWait(func() { vc.failover.watch(ctx) })
+	}
+
+	go func() {
+		<-ctx.Done()
+		vc.udpServer.Close()
+	}()
+
+	return nil
+}
+
+// Stop stops everything started by Start, waits for it to finish and
+// writes the cached doms to the cache dir.
+func (vc *NacosClient) Stop() error {
+	return vc.stop(true)
+}
+
+// stop is Stop, the cache dir is left as it is unless flush is set.
+func (vc *NacosClient) stop(flush bool) error {
+	if vc.cancel == nil {
+		return nil
+	}
+
+	vc.cancel()
+	vc.udpServer.Close()
+	vc.wg.Wait()
+
+	CacheSize.Sub(float64(vc.cacheSize))
+	vc.cacheSize = 0
+
+	if !flush {
+		return nil
+	}
+	return vc.flushCache()
+}
+
+func (vc *NacosClient) goWithWait(fn func()) {
+	vc.wg.Add(1)
+	go func() {
+		defer vc.wg.Done()
+		fn()
+	}()
+}
+
+// flushCache writes every cached dom with instances to the cache dir.
+func (vc *NacosClient) flushCache() error {
+	var lastErr error
+	for key, v := range vc.domainMap.Items() {
+		domain := v.(Domain)
+		if len(domain.Instances) == 0 {
+			continue
+		}
+
+		fileName, err := vc.cacheFile(key)
+		if err == nil {
+			err = ioutil.WriteFile(fileName, []byte(domain.String()), 0666)
+		}
+		if err != nil {
+			vc.Logger().Error("failed to write cache "+key, err)
+			lastErr = err
+		}
+	}
+
+	vc.Logger().Flush()
+	return lastErr
+}
+
+// SetFailoverDir makes the client answer from the dom files in dir whenever
+// the failover switch file in dir is turned on. The dir is watched for
+// changes once the client is started.
+func (vc *NacosClient) SetFailoverDir(dir string) {
+	vc.failover = NewFailoverReactor(dir)
+	vc.failover.logger = vc.Logger()
+	vc.failover.refresh()
+}
+
+// FailoverDomain returns the failover data of dom if failover is switched on.
+func (vc *NacosClient) FailoverDomain(dom string) (Domain, bool) {
+	return vc.failover.Domain(dom)
 }
 
 func (vc *NacosClient) GetDomainCache() ConcurrentMap {
@@ -283,11 +555,9 @@ func (vc *NacosClient) GetDomain(name string) (*Domain, error) {
 
 	if item == nil {
 		domain := Domain{}
-		ss := strings.Split(name, SEPERATOR)
-
-		domain.Name = ss[0]
+		domain.Name, _ = SplitCacheKey(name)
 		domain.CacheMillis = DefaultCacheMillis
-		domain.LastRefMillis = CurrentMillis()
+		domain.LastRefMillis = vc.now()
 		vc.domainMap.Set(name, domain)
 		item = domain
 		return nil, NacosClientError{"domain not found: " + name}
@@ -298,24 +568,35 @@ func (vc *NacosClient) GetDomain(name string) (*Domain, error) {
 	return &domain, nil
 }
 
-func (vc *NacosClient) asyncUpdateDomain() {
+func (vc *NacosClient) asyncUpdateDomain(ctx context.Context) {
 	for {
-		for k, v := range vc.domainMap.Items() {
+		items := vc.domainMap.Items()
+		CacheSize.Add(float64(len(items) - vc.cacheSize))
+		vc.cacheSize = len(items)
+
+		for k, v := range items {
 			dom := v.(Domain)
-			ss := strings.Split(k, SEPERATOR)
+			domName, clientIP := SplitCacheKey(k)
+			RefreshLag.WithLabelValues(domName).Set(float64(vc.now()-dom.LastRefMillis) / 1000)
 
-			domName := ss[0]
-			var clientIP string
-			if len(ss) > 1 && ss[1] != "" {
-				clientIP = ss[1]
-			}
+			if vc.now()-dom.LastRefMillis > dom.CacheMillis && vc.Registered(domName) {
 
-			if CurrentMillis()-dom.LastRefMillis > dom.CacheMillis && vc.Registered(domName) {
+				vc.getDomNow(ctx, domName, &vc.domainMap, clientIP)
+			}
+		}
 
-				vc.getDomNow(domName, &vc.domainMap, clientIP)
+		// subscribed doms no query cached yet, refreshed with the others from then on
+		for _, domName := range vc.listeners.doms() {
+			if !vc.domainMap.Has(GetCacheKey(domName, LocalIP())) && vc.Registered(domName) {
+				vc.getDomNow(ctx, domName, &vc.domainMap, LocalIP())
 			}
 		}
-		time.Sleep(1 * time.Second)
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-vc.Clock().After(1 * time.Second):
+		}
 	}
 
 }
@@ -324,76 +605,105 @@ func GetCacheKey(dom, clientIP string) string {
 	return dom + SEPERATOR + clientIP
 }
 
-func (vc *NacosClient) getDomNow(domainName string, cache *ConcurrentMap, clientIP string) Domain {
-	params := make(map[string]string)
-	params["dom"] = domainName
+// SplitCacheKey is the reverse of GetCacheKey. Doms may contain SEPERATOR
+// themselves, so only a trailing client IP is split off.
+func SplitCacheKey(key string) (dom, clientIP string) {
+	return nacoscache.SplitKey(key)
+}
 
-	if clientIP != "" {
-		params["clientIP"] = clientIP
-	}
+// getDomNow queries nacos for domainName, which is a service key as
+// returned by Service.Key.
+func (vc *NacosClient) getDomNow(ctx context.Context, domainName string, cache *ConcurrentMap, clientIP string) Domain {
+	domain, _ := vc.fetchDom(ctx, domainName, cache, clientIP)
+	return domain
+}
 
+// fetchDom is getDomNow reporting why nothing was cached, a dom nacos has
+// no instances of is returned with nacoscache.ErrNoInstances.
+func (vc *NacosClient) fetchDom(ctx context.Context, domainName string, cache *ConcurrentMap, clientIP string) (Domain, error) {
 	ip := vc.serverManager.NextServer()
 
-	s := Get("http://"+ip+":"+strconv.Itoa(vc.serverPort)+"/nacos/v1/ns/api/srvIPXT?", params)
+	s, err := nacoscache.Fetch(ctx, vc.get, ip+":"+strconv.Itoa(vc.serverPort), vc.params(), domainName, clientIP)
 
 	if s == "" {
-		NacosClientLogger.Warn("empty result from server, dom:" + domainName)
-		return Domain{}
+		vc.Logger().Warn("empty result from server, dom:" + domainName)
+		if err == nil {
+			err = NacosClientError{"empty result from server"}
+		}
+		return Domain{}, err
 	}
+	vc.markContact()
 
-	domain, err1 := ProcessDomainString(s)
+	domain, err1 := processDomainString(s, vc.Logger())
 	if err1 != nil {
 		domain.Name = domainName
-		return domain
+		return domain, err1
 	}
 
 	cacheKey := GetCacheKey(domainName, clientIP)
 
 	oldDomain, ok := cache.Get(cacheKey)
 
-	if !ok || ok && !reflect.DeepEqual(domain.Instances, oldDomain.(Domain).Instances) {
+	if !ok || ok && !sameInstances(domain.Instances, oldDomain.(Domain).Instances) {
 		if !ok {
-			NacosClientLogger.Info("dom not found in cache " + cacheKey)
+			vc.Logger().Info("dom not found in cache " + cacheKey)
 			oldDomain = Domain{}
 		}
 
-		NacosClientLogger.Info("dom "+cacheKey+" updated: ", domain)
+		vc.Logger().Info("dom "+cacheKey+" updated: ", domain)
 	}
 
-	domFileName := CachePath + string(os.PathSeparator) + cacheKey
-
-	err := ioutil.WriteFile(domFileName, []byte(s), 0666)
+	domFileName, err := vc.cacheFile(cacheKey)
+	if err == nil {
+		err = ioutil.WriteFile(domFileName, []byte(s), 0666)
+	}
 	if err != nil {
-		NacosClientLogger.Error("faild to write cache "+cacheKey+", value: "+s, err)
+		vc.Logger().Error("faild to write cache "+cacheKey+", value: "+s, err)
 	}
 
-	domain.LastRefMillis = CurrentMillis()
+	domain.LastRefMillis = vc.now()
 	cache.Set(cacheKey, domain)
-	return domain
+	vc.listeners.notify(vc.Logger(), domainName, clientIP, oldDomain.(Domain).Instances, domain.Instances)
+	return domain, nil
 }
 
 func (vc *NacosClient) SrvInstance(domainName, clientIP string) *Instance {
+	return vc.SrvInstanceContext(context.Background(), domainName, clientIP)
+}
+
+// SrvInstanceContext is SrvInstance as part of the trace in ctx.
+func (vc *NacosClient) SrvInstanceContext(ctx context.Context, domainName, clientIP string) *Instance {
+	span, ctx := startSpan(ctx, SpanCache)
+	defer span.Finish()
+
 	cacheKey := GetCacheKey(domainName, clientIP)
 	item, hasDom := vc.domainMap.Get(cacheKey)
+	span.SetTag("hit", hasDom)
 	var dom Domain
-	if !hasDom {
+	if failoverDom, ok := vc.FailoverDomain(domainName); ok {
+		CacheHits.Inc()
+		span.SetTag("failover", true)
+		dom = failoverDom
+	} else if !hasDom {
+		CacheMisses.Inc()
 		dom = Domain{}
-		dom.LastRefMillis = CurrentMillis()
+		dom.LastRefMillis = vc.now()
 		dom.CacheMillis = DefaultCacheMillis
 		vc.domainMap.Set(GetCacheKey(domainName, clientIP), dom)
-		dom = vc.getDomNow(domainName, &vc.domainMap, clientIP)
+		dom = vc.getDomNow(ctx, domainName, &vc.domainMap, clientIP)
 	} else {
+		CacheHits.Inc()
 		dom = item.(Domain)
 	}
 
 	hosts := dom.SrvInstances()
 
 	if len(hosts) == 0 {
-		NacosClientLogger.Warn("no hosts for " + domainName)
+		vc.Logger().Warn("no hosts for " + domainName)
 		return nil
 	}
 
-	i, indexOk := indexMap.Get(domainName)
+	i, indexOk := vc.indexMap.Get(domainName)
 	var index int
 
 	if !indexOk {
@@ -406,7 +716,7 @@ func (vc *NacosClient) SrvInstance(domainName, clientIP string) *Instance {
 		}
 	}
 
-	indexMap.Set(domainName, index)
+	vc.indexMap.Set(domainName, index)
 
 	return &hosts[index]
 }
@@ -416,12 +726,17 @@ func (vc *NacosClient) SrvInstances(domainName, clientIP string) []Instance {
 	item, hasDom := vc.domainMap.Get(cacheKey)
 	var dom Domain
 
-	if !hasDom {
+	if failoverDom, ok := vc.FailoverDomain(domainName); ok {
+		CacheHits.Inc()
+		dom = failoverDom
+	} else if !hasDom {
+		CacheMisses.Inc()
 		dom = Domain{}
 		dom.Name = domainName
 		vc.domainMap.Set(cacheKey, dom)
-		dom = vc.getDomNow(domainName, &vc.domainMap, clientIP)
+		dom = vc.getDomNow(context.Background(), domainName, &vc.domainMap, clientIP)
 	} else {
+		CacheHits.Inc()
 		dom = item.(Domain)
 	}
 
@@ -441,3 +756,81 @@ func (vc *NacosClient) Contains(dom, clientIP string, host Instance) bool {
 
 	return false
 }
+
+// DomsByIP returns the cached doms with a valid instance on ip.
+func (vc *NacosClient) DomsByIP(ip string) []string {
+	var doms []string
+	seen := make(map[string]bool)
+	for k, v := range vc.domainMap.Items() {
+		dom, _ := SplitCacheKey(k)
+		if seen[dom] {
+			continue
+		}
+
+		for _, host := range v.(Domain).SrvInstances() {
+			if host.IP == ip {
+				seen[dom] = true
+				doms = append(doms, dom)
+				break
+			}
+		}
+	}
+
+	return doms
+}
+
+// Purge removes a cache key from the cache and the cache dir, it reports
+// whether the key was cached.
+func (vc *NacosClient) Purge(key string) bool {
+	if _, ok := vc.domainMap.Pop(key); !ok {
+		return false
+	}
+
+	dom, _ := SplitCacheKey(key)
+	vc.indexMap.Remove(dom)
+	// the lag is reported by dom, it goes once the dom is not cached for any client IP
+	if !vc.cached(dom) {
+		RefreshLag.DeleteLabelValues(dom)
+	}
+	if fileName, err := vc.cacheFile(key); err != nil {
+		vc.Logger().Warn("failed to remove cache file of "+key, err)
+	} else if err := os.Remove(fileName); err != nil && !os.IsNotExist(err) {
+		vc.Logger().Warn("failed to remove cache file of "+key, err)
+	}
+	vc.Logger().Info("purged " + key)
+	return true
+}
+
+// cached reports whether dom is cached for any client IP.
+func (vc *NacosClient) cached(dom string) bool {
+	for _, key := range vc.domainMap.Keys() {
+		if d, _ := SplitCacheKey(key); d == dom {
+			return true
+		}
+	}
+	return false
+}
+
+// Invalidate purges the cache keys of dom for every client IP and returns them.
+func (vc *NacosClient) Invalidate(dom string) []string {
+	keys := make([]string, 0)
+	for _, key := range vc.domainMap.Keys() {
+		if d, _ := SplitCacheKey(key); d == dom && vc.Purge(key) {
+			keys = append(keys, key)
+		}
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+// PurgeAll purges every cache key and returns them.
+func (vc *NacosClient) PurgeAll() []string {
+	keys := make([]string, 0)
+	for _, key := range vc.domainMap.Keys() {
+		if vc.Purge(key) {
+			keys = append(keys, key)
+		}
+	}
+	sort.Strings(keys)
+	return keys
+}
@@ -14,11 +14,19 @@
 package nacos
 
 import (
-	"testing"
-	"strings"
-	"net/http/httptest"
+	"context"
+	"io/ioutil"
+	"net"
 	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
 	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/nacos-group/nacos-coredns-plugin/nacos/nacostest"
 )
 
 func TestNacosClient_GetDomain(t *testing.T) {
@@ -38,7 +46,7 @@ func TestNacosClient_GetDomain(t *testing.T) {
 
 	defer server.Close()
 
-	vc := NacosClient{NewConcurrentMap(), UDPServer{}, ServerManager{}, port}
+	vc := NacosClient{domainMap: NewConcurrentMap(), indexMap: NewConcurrentMap(), cachePath: t.TempDir(), serverPort: port}
 	vc.udpServer.vipClient = &vc
 	vc.SetServers([]string{strings.Split(strings.Split(server.URL, "http://")[1], ":")[0]})
 	instance := vc.SrvInstance("hello123", "127.0.0.1")
@@ -46,3 +54,314 @@ func TestNacosClient_GetDomain(t *testing.T) {
 		t.Log("Passed")
 	}
 }
+
+func TestNacosClient_Isolated(t *testing.T) {
+	vc1 := NacosClient{domainMap: NewConcurrentMap(), indexMap: NewConcurrentMap(), cachePath: t.TempDir()}
+	vc2 := NacosClient{domainMap: NewConcurrentMap(), indexMap: NewConcurrentMap(), cachePath: t.TempDir()}
+
+	vc1.allDoms.Data = map[string]bool{"hello123": true}
+	vc2.allDoms.Data = map[string]bool{"world456": true}
+
+	if !vc1.Registered("hello123") || vc1.Registered("world456") {
+		t.Fatal("unexpected doms in first client: ", vc1.AllDomNames())
+	}
+	if !vc2.Registered("world456") || vc2.Registered("hello123") {
+		t.Fatal("unexpected doms in second client: ", vc2.AllDomNames())
+	}
+	if vc1.CachePath() == vc2.CachePath() {
+		t.Fatal("expected clients to have their own cache dirs")
+	}
+}
+
+func TestNacosClient_RegisteredNamespaces(t *testing.T) {
+	server := nacostest.NewServer()
+	defer server.Close()
+	server.SetService("hello123", nacostest.Instance{IP: "2.2.2.2", Port: 81, Weight: 1, Valid: true})
+	server.SetService("dev##world456", nacostest.Instance{IP: "5.5.5.5", Port: 8080, Weight: 1, Valid: true})
+
+	dir, err := ioutil.TempDir("", "nacos-cache")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	vc := NewNacosClientWithConfig(ClientConfig{Servers: []string{server.Host()}, ServerPort: server.Port(), CachePath: dir})
+	vc.getAllDomNames()
+
+	for dom, registered := range map[string]bool{"hello123": true, "public##hello123": true, "dev##world456": true,
+		"world456": false, "dev##hello123": false} {
+		if vc.Registered(dom) != registered {
+			t.Errorf("expected %s registered to be %v, doms are %v", dom, registered, vc.AllDomNames())
+		}
+	}
+}
+
+func TestNacosClient_DefaultCachePath(t *testing.T) {
+	dir, err := ioutil.TempDir("", "nacos-cache")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	defer func(path string) { DefaultCachePath = path }(DefaultCachePath)
+	DefaultCachePath = dir
+
+	vc1 := NewNacosClientWithConfig(ClientConfig{Servers: []string{"10.0.0.1"}, ServerPort: 8848})
+	vc2 := NewNacosClientWithConfig(ClientConfig{Servers: []string{"10.1.0.1"}, ServerPort: 8848})
+	if vc1.CachePath() == vc2.CachePath() || filepath.Dir(vc1.CachePath()) != dir || !Exist(vc1.CachePath()) {
+		t.Fatalf("expected clients of other servers to have their own dirs in %s, got %s and %s", dir, vc1.CachePath(), vc2.CachePath())
+	}
+}
+
+func TestNacosClient_StartStop(t *testing.T) {
+	server := nacostest.NewServer()
+	defer server.Close()
+	server.SetService("hello123", nacostest.Instance{IP: "2.2.2.2", Port: 81, Weight: 1, Valid: true})
+	port := server.Port()
+
+	dir, err := ioutil.TempDir("", "nacos-cache")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	vc := NewNacosClientWithConfig(ClientConfig{Servers: []string{"127.0.0.1"}, ServerPort: port, CachePath: dir})
+	if err := vc.Start(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+
+	if !vc.Registered("hello123") {
+		t.Fatal("expected doms to be fetched on start")
+	}
+	vc.SrvInstances("hello123", "127.0.0.1")
+	os.RemoveAll(dir)
+	os.Mkdir(dir, 0755)
+
+	udpPort := vc.GetUdpServer().port
+	if err := vc.Stop(); err != nil {
+		t.Fatal(err)
+	}
+
+	conn, err := net.ListenUDP("udp", &net.UDPAddr{Port: udpPort})
+	if err != nil {
+		t.Fatal("expected push port to be released on stop, ", err)
+	}
+	conn.Close()
+
+	if !Exist(filepath.Join(dir, GetCacheKey("hello123", "127.0.0.1"))) {
+		t.Fatal("expected cache to be flushed on stop")
+	}
+}
+
+func TestNacos_OnShutdownAfterRestart(t *testing.T) {
+	server := nacostest.NewServer()
+	defer server.Close()
+	server.SetService("hello123", nacostest.Instance{IP: "2.2.2.2", Port: 81, Weight: 1, Valid: true})
+
+	dir, err := ioutil.TempDir("", "nacos-cache")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	vc := NewNacosClientWithConfig(ClientConfig{Servers: []string{"127.0.0.1"}, ServerPort: server.Port(), CachePath: dir})
+	vs := &Nacos{NacosClientImpl: vc}
+	if err := vs.OnStartup(); err != nil {
+		t.Fatal(err)
+	}
+	vc.SrvInstances("hello123", "127.0.0.1")
+	os.RemoveAll(dir)
+	os.Mkdir(dir, 0755)
+
+	vs.OnRestart()
+	if err := vs.OnShutdown(); err != nil {
+		t.Fatal(err)
+	}
+
+	if Exist(filepath.Join(dir, GetCacheKey("hello123", "127.0.0.1"))) {
+		t.Fatal("expected cache not to be flushed on shutdown for a reload")
+	}
+}
+
+func TestNacosClient_Push(t *testing.T) {
+	server := nacostest.NewServer()
+	defer server.Close()
+	server.SetService("hello123", nacostest.Instance{IP: "2.2.2.2", Port: 81, Weight: 1, Valid: true})
+
+	dir, err := ioutil.TempDir("", "nacos-cache")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	vc := NewNacosClientWithConfig(ClientConfig{Servers: []string{server.Host()}, ServerPort: server.Port(), CachePath: dir})
+	if err := vc.Start(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	defer vc.Stop()
+
+	// the first query subscribes the client to pushes
+	if instance := vc.SrvInstance("hello123", LocalIP()); instance == nil || instance.IP != "2.2.2.2" {
+		t.Fatalf("unexpected instance %v", instance)
+	}
+
+	server.SetService("hello123", nacostest.Instance{IP: "3.3.3.3", Port: 81, Weight: 1, Valid: true})
+	if acked, err := server.Push("hello123"); err != nil || acked != 1 {
+		t.Fatalf("expected the push to be acked, got %d %v", acked, err)
+	}
+	if instance := vc.SrvInstance("hello123", LocalIP()); instance == nil || instance.IP != "3.3.3.3" {
+		t.Fatalf("expected the pushed instance, got %v", instance)
+	}
+}
+
+func TestNacosClient_Refresh(t *testing.T) {
+	server := nacostest.NewServer()
+	defer server.Close()
+	server.SetService("hello123", nacostest.Instance{IP: "2.2.2.2", Port: 81, Weight: 1, Valid: true})
+
+	dir, err := ioutil.TempDir("", "nacos-cache")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	clock := nacostest.NewClock(time.Unix(1500000000, 0))
+	vc := NewNacosClientWithConfig(ClientConfig{Servers: []string{server.Host()}, ServerPort: server.Port(), CachePath: dir, Clock: clock})
+	if err := vc.Start(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	defer vc.Stop()
+
+	// both loops wait for their next round
+	clock.BlockUntil(2)
+	if instance := vc.SrvInstance("hello123", "127.0.0.1"); instance == nil || instance.IP != "2.2.2.2" {
+		t.Fatalf("unexpected instance %v", instance)
+	}
+	server.SetService("hello123", nacostest.Instance{IP: "3.3.3.3", Port: 81, Weight: 1, Valid: true})
+
+	// the dom is cached for the cacheMillis of the server, 10s
+	clock.Advance(9 * time.Second)
+	clock.BlockUntil(2)
+	if instance := vc.SrvInstance("hello123", "127.0.0.1"); instance == nil || instance.IP != "2.2.2.2" || server.Requests(nacostest.PathSrvIPXT) != 1 {
+		t.Fatalf("expected the cached instance, got %v after %d requests", instance, server.Requests(nacostest.PathSrvIPXT))
+	}
+
+	clock.Advance(2 * time.Second)
+	clock.BlockUntil(2)
+	if instance := vc.SrvInstance("hello123", "127.0.0.1"); instance == nil || instance.IP != "3.3.3.3" {
+		t.Fatalf("expected the refreshed instance, got %v", instance)
+	}
+}
+
+func TestNacosClient_Subscribe(t *testing.T) {
+	server := nacostest.NewServer()
+	defer server.Close()
+	server.SetService("hello123", nacostest.Instance{IP: "2.2.2.2", Port: 81, Weight: 1, Valid: true})
+
+	dir, err := ioutil.TempDir("", "nacos-cache")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	vc := NewNacosClientWithConfig(ClientConfig{Servers: []string{server.Host()}, ServerPort: server.Port(), CachePath: dir})
+	var events []ServiceEvent
+	id := vc.Subscribe("hello123", func(event ServiceEvent) { events = append(events, event) })
+
+	vc.getDomNow(context.TODO(), "hello123", &vc.domainMap, "10.0.0.1")
+	vc.getDomNow(context.TODO(), "hello123", &vc.domainMap, "10.0.0.1")
+	if len(events) != 1 || events[0].ClientIP != "10.0.0.1" || len(events[0].Old) != 0 || events[0].New[0].IP != "2.2.2.2" {
+		t.Fatalf("expected one event for the first refresh, got %+v", events)
+	}
+
+	push := `{"type":"dom","data":"{\"dom\":\"hello123\",\"hosts\":[{\"ip\":\"3.3.3.3\",\"port\":81,\"weight\":1,\"valid\":true}]}","lastRefTime":1}`
+	if _, ok := vc.udpServer.handlePush([]byte(push)); !ok {
+		t.Fatal("expected the push to be accepted")
+	}
+	if len(events) != 2 || events[1].ClientIP != LocalIP() || events[1].New[0].IP != "3.3.3.3" {
+		t.Fatalf("expected an event for the push, got %+v", events)
+	}
+
+	empty := `{"type":"dom","data":"{\"dom\":\"hello123\",\"hosts\":[]}","lastRefTime":2}`
+	if ack, ok := vc.udpServer.handlePush([]byte(empty)); !ok || !strings.Contains(string(ack), `"lastRefTime":"2"`) {
+		t.Fatal("expected the push of a dom without instances to be acked")
+	}
+	if len(events) != 3 || events[2].Old[0].IP != "3.3.3.3" || len(events[2].New) != 0 {
+		t.Fatalf("expected an event for the removed instances, got %+v", events)
+	}
+	if _, ok := vc.domainMap.Get(GetCacheKey("hello123", LocalIP())); ok {
+		t.Fatal("expected the dom without instances to be evicted")
+	}
+
+	vc.Unsubscribe("hello123", id)
+	server.SetService("hello123", nacostest.Instance{IP: "4.4.4.4", Port: 81, Weight: 1, Valid: true})
+	vc.getDomNow(context.TODO(), "hello123", &vc.domainMap, "10.0.0.1")
+	if len(events) != 3 {
+		t.Fatalf("expected no events after unsubscribe, got %+v", events)
+	}
+}
+
+func TestNacosClient_SubscribeWatches(t *testing.T) {
+	server := nacostest.NewServer()
+	defer server.Close()
+	server.SetService("hello123", nacostest.Instance{IP: "2.2.2.2", Port: 81, Weight: 1, Valid: true})
+
+	dir, err := ioutil.TempDir("", "nacos-cache")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	clock := nacostest.NewClock(time.Unix(1500000000, 0))
+	vc := NewNacosClientWithConfig(ClientConfig{Servers: []string{server.Host()}, ServerPort: server.Port(), CachePath: dir, Clock: clock})
+	events := make(chan ServiceEvent, 2)
+	vc.Subscribe("hello123", func(event ServiceEvent) { events <- event })
+
+	if err := vc.Start(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	defer vc.Stop()
+
+	// nothing queried the service, the first refresh fetches it for the listener
+	clock.BlockUntil(2)
+	event := <-events
+	if event.ClientIP != LocalIP() || len(event.Old) != 0 || len(event.New) != 1 || event.New[0].IP != "2.2.2.2" {
+		t.Fatalf("unexpected event %+v", event)
+	}
+
+	// and subscribed the client to pushes
+	server.SetService("hello123", nacostest.Instance{IP: "3.3.3.3", Port: 81, Weight: 1, Valid: true})
+	if acked, err := server.Push("hello123"); err != nil || acked != 1 {
+		t.Fatalf("expected the push to be acked, got %d %v", acked, err)
+	}
+	if event := <-events; event.Old[0].IP != "2.2.2.2" || event.New[0].IP != "3.3.3.3" {
+		t.Fatalf("unexpected event %+v", event)
+	}
+}
+
+func TestNacosClient_SubscribeUnregistered(t *testing.T) {
+	vc := &NacosClient{domainMap: NewConcurrentMap(), indexMap: NewConcurrentMap(), cachePath: t.TempDir()}
+	vc.allDoms.Data = map[string]bool{"hello123": true}
+	vc.Subscribe("world456", func(ServiceEvent) {})
+
+	if vc.Managed("world456", LocalIP()) || !vc.domainMap.IsEmpty() {
+		t.Fatal("expected a subscribed dom that is not registered to stay unmanaged")
+	}
+	if !vc.Managed("hello123", LocalIP()) {
+		t.Fatal("expected a registered dom to be managed")
+	}
+}
+
+func TestNacosClient_CacheFile(t *testing.T) {
+	vc := &NacosClient{cachePath: "/tmp/nacos-cache"}
+	if file, err := vc.cacheFile("hello123@@10.0.0.1"); err != nil || file != "/tmp/nacos-cache/hello123@@10.0.0.1" {
+		t.Fatalf("unexpected cache file %s: %v", file, err)
+	}
+	for _, key := range []string{"", ".", "..", "../hello123@@", "a/b@@", "a\\b@@"} {
+		if _, err := vc.cacheFile(key); err == nil {
+			t.Fatalf("expected cache key %q to be refused", key)
+		}
+	}
+	if _, err := (&NacosClient{}).cacheFile("hello123@@10.0.0.1"); err == nil {
+		t.Fatal("expected a client without cache dir to refuse every key")
+	}
+}
@@ -13,45 +13,10 @@
 
 package nacos
 
-import (
-	"math"
-	"encoding/json"
-)
+import "github.com/nacos-group/nacos-coredns-plugin/nacos/nacoscache"
 
-type Domain struct {
-	Name string `json:"dom"`
-	Clusters string
-	CacheMillis int64
-	LastRefMillis int64
-	Instances []Instance `json:"hosts"`
-	Env string
-	TTL int
+// Domain is a dom as nacos answers it and the client caches it.
+type Domain = nacoscache.Domain
 
-}
-
-func (domain Domain) getInstances() ([]Instance) {
-	return domain.Instances
-}
-
-func (domain Domain) String() string {
-	b, _ := json.Marshal(domain)
-	return string(b)
-}
-
-func (domain Domain) SrvInstances() []Instance {
-	var result = make([]Instance, 0)
-	hosts := domain.getInstances()
-	for _, host := range hosts {
-		if host.Valid && host.Weight > 0 {
-			for i := 0; i < int(math.Ceil(host.Weight)); i++ {
-				result = append(result, host)
-			}
-		}
-	}
-
-	if len(result) <= 0{
-		panic("no host to srv: " + domain.Name)
-	}
-
-	return result
-}
+// Instance is an instance of a Domain.
+type Instance = nacoscache.Instance
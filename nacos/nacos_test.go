@@ -0,0 +1,222 @@
+/*
+ * Copyright 1999-2018 Alibaba Group Holding Ltd.
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *      http://www.apache.org/licenses/LICENSE-2.0
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package nacos
+
+import (
+	"context"
+	"testing"
+
+	"github.com/coredns/coredns/plugin/pkg/dnstest"
+	"github.com/coredns/coredns/plugin/test"
+	"github.com/coredns/coredns/request"
+	"github.com/miekg/dns"
+)
+
+func TestNacos_upstreamFor(t *testing.T) {
+	public := NewForwarder([]string{"8.8.8.8:53"})
+	corp := NewForwarder([]string{"10.0.0.1:53"})
+	dev := NewForwarder([]string{"10.0.1.1:53"})
+
+	vs := Nacos{Upstream: public, ZoneUpstreams: map[string]*Forwarder{
+		"corp.example.":     corp,
+		"dev.corp.example.": dev,
+	}}
+
+	tests := []struct {
+		name     string
+		expected *Forwarder
+	}{
+		{"www.example.org.", public},
+		{"corp.example.", corp},
+		{"git.CORP.example.", corp},
+		{"db.dev.corp.example.", dev},
+		{"notcorp.example.", public},
+	}
+
+	for _, test := range tests {
+		if f := vs.upstreamFor(test.name); f != test.expected {
+			t.Errorf("unexpected upstream for %s: %v", test.name, f.Upstreams()[0].Addr())
+		}
+	}
+}
+
+func TestNacos_ServeDNSFallthrough(t *testing.T) {
+	vs := Nacos{NacosClientImpl: &NacosClient{domainMap: NewConcurrentMap(), cachePath: t.TempDir()}, DNSCache: NewConcurrentMap()}
+	vs.Next = test.NextHandler(dns.RcodeRefused, nil)
+
+	r := new(dns.Msg)
+	r.SetQuestion("www.example.org.", dns.TypeA)
+
+	// neither upstream nor fallthrough
+	rec := dnstest.NewRecorder(&test.ResponseWriter{})
+	if code, _ := vs.ServeDNS(context.TODO(), rec, r); code != dns.RcodeServerFailure {
+		t.Fatalf("expected SERVFAIL without upstream, got %s", dns.RcodeToString[code])
+	}
+
+	vs.Fall.SetZonesFromArgs([]string{"org"})
+	rec = dnstest.NewRecorder(&test.ResponseWriter{})
+	if code, _ := vs.ServeDNS(context.TODO(), rec, r); code != dns.RcodeRefused {
+		t.Fatalf("expected query to fall through to next plugin, got %s", dns.RcodeToString[code])
+	}
+
+	r.SetQuestion("www.example.com.", dns.TypeA)
+	rec = dnstest.NewRecorder(&test.ResponseWriter{})
+	if code, _ := vs.ServeDNS(context.TODO(), rec, r); code != dns.RcodeServerFailure {
+		t.Fatalf("expected no fallthrough outside of the zones, got %s", dns.RcodeToString[code])
+	}
+}
+
+func TestNacos_ServeDNSNameMapping(t *testing.T) {
+	client := &NacosClient{domainMap: NewConcurrentMap(), indexMap: NewConcurrentMap(), cachePath: t.TempDir()}
+	client.domainMap.Set(GetCacheKey("dev##DEFAULT_GROUP@@providers:com.foo.Bar:1.0", "10.240.0.1"), Domain{
+		Name: "providers:com.foo.Bar:1.0", Instances: []Instance{{IP: "2.2.2.2", Port: 20880, Weight: 1, Valid: true}}})
+	vs := Nacos{NacosClientImpl: client, DNSCache: NewConcurrentMap(), Mapper: dubboMapper(), TTL: 1}
+
+	r := new(dns.Msg)
+	r.SetQuestion("com.foo.Bar.v1.0.DUBBO.", dns.TypeA)
+	rec := dnstest.NewRecorder(&test.ResponseWriter{})
+	if code, err := vs.ServeDNS(context.TODO(), rec, r); code != dns.RcodeSuccess {
+		t.Fatalf("expected success, got %s: %v", dns.RcodeToString[code], err)
+	}
+	if len(rec.Msg.Answer) != 1 || rec.Msg.Answer[0].(*dns.A).A.String() != "2.2.2.2" {
+		t.Fatalf("unexpected answer %v", rec.Msg.Answer)
+	}
+	if len(rec.Msg.Extra) != 1 || rec.Msg.Extra[0].(*dns.SRV).Target != "com.foo.Bar.v1.0.dubbo." {
+		t.Fatalf("unexpected extra %v", rec.Msg.Extra)
+	}
+
+	r.SetQuestion("2.2.2.2.in-addr.arpa.", dns.TypePTR)
+	rec = dnstest.NewRecorder(&test.ResponseWriter{})
+	if code, err := vs.ServeDNS(context.TODO(), rec, r); code != dns.RcodeSuccess {
+		t.Fatalf("expected success, got %s: %v", dns.RcodeToString[code], err)
+	}
+	if len(rec.Msg.Answer) != 1 || rec.Msg.Answer[0].(*dns.PTR).Ptr != "com.foo.Bar.v1.0.dubbo." {
+		t.Fatalf("unexpected answer %v", rec.Msg.Answer)
+	}
+}
+
+func TestNacos_ServeDNSExclude(t *testing.T) {
+	client := &NacosClient{domainMap: NewConcurrentMap(), indexMap: NewConcurrentMap(), cachePath: t.TempDir()}
+	client.domainMap.Set(GetCacheKey("admin-console", "10.240.0.1"), Domain{
+		Name: "admin-console", Instances: []Instance{{IP: "2.2.2.2", Port: 80, Weight: 1, Valid: true}}})
+	exclude, _ := NewServiceMatcher("admin-*", "", "")
+	vs := Nacos{NacosClientImpl: client, DNSCache: NewConcurrentMap(), Filter: ServiceFilter{Exclude: []ServiceMatcher{exclude}}}
+	vs.Next = test.NextHandler(dns.RcodeRefused, nil)
+
+	r := new(dns.Msg)
+	r.SetQuestion("admin-console.", dns.TypeA)
+	rec := dnstest.NewRecorder(&test.ResponseWriter{})
+	if code, _ := vs.ServeDNS(context.TODO(), rec, r); code != dns.RcodeNameError || len(rec.Msg.Answer) != 0 {
+		t.Fatalf("expected NXDOMAIN for excluded service, got %s %v", dns.RcodeToString[code], rec.Msg.Answer)
+	}
+
+	vs.Fall.SetZonesFromArgs(nil)
+	rec = dnstest.NewRecorder(&test.ResponseWriter{})
+	if code, _ := vs.ServeDNS(context.TODO(), rec, r); code != dns.RcodeRefused {
+		t.Fatalf("expected excluded service to fall through to next plugin, got %s", dns.RcodeToString[code])
+	}
+}
+
+func TestNacos_ServeDNSNoValidInstance(t *testing.T) {
+	client := &NacosClient{domainMap: NewConcurrentMap(), indexMap: NewConcurrentMap(), cachePath: t.TempDir()}
+	client.domainMap.Set(GetCacheKey("hello123", "10.240.0.1"), Domain{
+		Name: "hello123", Instances: []Instance{{IP: "2.2.2.2", Port: 80, Weight: 1, Valid: false}}})
+	vs := Nacos{NacosClientImpl: client, DNSCache: NewConcurrentMap()}
+
+	r := new(dns.Msg)
+	r.SetQuestion("hello123.", dns.TypeA)
+	rec := dnstest.NewRecorder(&test.ResponseWriter{})
+	if code, _ := vs.ServeDNS(context.TODO(), rec, r); code != dns.RcodeServerFailure || len(rec.Msg.Answer) != 0 {
+		t.Fatalf("expected SERVFAIL without valid instance, got %s", dns.RcodeToString[code])
+	}
+}
+
+func TestNacos_ServeDNSStatic(t *testing.T) {
+	discovery, err := NewStaticDiscovery("testdata/registry.yaml", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	vs := Nacos{Discovery: discovery, DNSCache: NewConcurrentMap(), TTL: 30}
+	vs.Fall.SetZonesFromArgs(nil)
+	vs.Next = test.NextHandler(dns.RcodeRefused, nil)
+
+	r := new(dns.Msg)
+	r.SetQuestion("hello123.", dns.TypeA)
+	rec := dnstest.NewRecorder(&test.ResponseWriter{})
+	if code, err := vs.ServeDNS(context.TODO(), rec, r); code != dns.RcodeSuccess {
+		t.Fatalf("expected success, got %s: %v", dns.RcodeToString[code], err)
+	}
+	if len(rec.Msg.Answer) != 1 || rec.Msg.Answer[0].(*dns.A).A.String() != "2.2.2.2" || rec.Msg.Extra[0].(*dns.SRV).Port != 80 {
+		t.Fatalf("unexpected answer %v %v", rec.Msg.Answer, rec.Msg.Extra)
+	}
+
+	r.SetQuestion("world456.", dns.TypeA)
+	if code, _ := vs.ServeDNS(context.TODO(), dnstest.NewRecorder(&test.ResponseWriter{}), r); code != dns.RcodeRefused {
+		t.Fatalf("expected services not in the file to fall through, got %s", dns.RcodeToString[code])
+	}
+	if !vs.Ready() || !vs.Health() {
+		t.Fatal("expected a static registry to be ready and healthy")
+	}
+}
+
+func TestNacos_LookupCached(t *testing.T) {
+	addr, stop := startUpstream(t, "2.2.2.2", false)
+	vs := Nacos{Upstream: NewForwarder([]string{addr}), DNSCache: NewConcurrentMap(), TTL: 30}
+
+	lookup := func() (*dns.Msg, error) {
+		state := request.Request{W: &test.ResponseWriter{}, Req: query("www.example.org.")}
+		return vs.LookupContext(context.TODO(), state, "www.example.org.", dns.TypeA)
+	}
+	if m, err := lookup(); err != nil || len(m.Answer) != 1 {
+		t.Fatalf("expected the answer of the upstream, got %v %v", m, err)
+	}
+
+	// the second query does not reach the upstream
+	stop()
+	vs.Upstream = NewForwarder([]string{"127.0.0.1:1"})
+	if m, err := lookup(); err != nil || len(m.Answer) != 1 || m.Answer[0].(*dns.A).A.String() != "2.2.2.2" {
+		t.Fatalf("expected the cached answer, got %v %v", m, err)
+	}
+
+	// the answer of A is not an answer of TXT
+	state := request.Request{W: &test.ResponseWriter{}, Req: query("www.example.org.")}
+	if m, err := vs.LookupContext(context.TODO(), state, "www.example.org.", dns.TypeTXT); err == nil {
+		t.Fatalf("expected TXT to be looked up, got %v", m)
+	}
+}
+
+func TestNacos_ServeDNSUpstreamNXDomain(t *testing.T) {
+	addr, stop := startUpstreamHandler(t, func(string) dns.HandlerFunc {
+		return func(w dns.ResponseWriter, r *dns.Msg) {
+			m := new(dns.Msg)
+			m.SetRcode(r, dns.RcodeNameError)
+			soa, _ := dns.NewRR("example.org. 60 IN SOA ns.example.org. admin.example.org. 1 7200 3600 1209600 60")
+			m.Ns = append(m.Ns, soa)
+			w.WriteMsg(m)
+		}
+	})
+	defer stop()
+	vs := Nacos{NacosClientImpl: &NacosClient{domainMap: NewConcurrentMap(), cachePath: t.TempDir()}, Upstream: NewForwarder([]string{addr}), DNSCache: NewConcurrentMap(), TTL: 30}
+
+	rec := dnstest.NewRecorder(&test.ResponseWriter{})
+	if code, err := vs.ServeDNS(context.TODO(), rec, query("nothing.example.org.")); code != dns.RcodeNameError || err != nil {
+		t.Fatalf("expected NXDOMAIN of the upstream, got %s: %v", dns.RcodeToString[code], err)
+	}
+	if rec.Msg.Rcode != dns.RcodeNameError || len(rec.Msg.Ns) != 1 {
+		t.Fatalf("expected NXDOMAIN with the SOA, got %v", rec.Msg)
+	}
+	if !vs.DNSCache.IsEmpty() {
+		t.Fatal("expected the negative answer not to be cached")
+	}
+}
@@ -0,0 +1,118 @@
+/*
+ * Copyright 1999-2018 Alibaba Group Holding Ltd.
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *      http://www.apache.org/licenses/LICENSE-2.0
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+// Package nacoscache reads doms the way the nacos plugin caches them. The
+// files in the cache dir of the plugin are doms as nacos answers them, named
+// by their cache key. The package does not import the plugin, so tools like
+// nacosctl build without CoreDNS.
+package nacoscache
+
+import (
+	"encoding/json"
+	"errors"
+	"math"
+)
+
+// ErrNoInstances is returned by Parse for a dom without instances, the
+// plugin neither caches nor loads such a dom.
+var ErrNoInstances = errors.New("empty ip list")
+
+// Domain is a dom as nacos answers it.
+type Domain struct {
+	Name          string `json:"dom"`
+	Clusters      string
+	CacheMillis   int64
+	LastRefMillis int64
+	Instances     []Instance `json:"hosts"`
+	Env           string
+	TTL           int
+}
+
+func (domain Domain) String() string {
+	b, _ := json.Marshal(domain)
+	return string(b)
+}
+
+// Instance is an instance of a Domain.
+type Instance struct {
+	IP         string
+	Port       int
+	Weight     float64
+	Valid      bool
+	Unit       string
+	AppUseType string
+	Site       string
+}
+
+func (h Instance) String() string {
+	bs, err := json.Marshal(&h)
+
+	if err != nil {
+		return ""
+	}
+
+	return string(bs)
+}
+
+// Parse parses a dom as returned by nacos and stored in the cache dir.
+func Parse(s string) (Domain, error) {
+	var domain Domain
+	if err := json.Unmarshal([]byte(s), &domain); err != nil {
+		return Domain{}, err
+	}
+
+	if len(domain.Instances) == 0 {
+		return domain, ErrNoInstances
+	}
+	return domain, nil
+}
+
+// MaxSrvInstances bounds the entries SrvInstances returns for the instances
+// of a domain, their weights are scaled down if they add up to more.
+var MaxSrvInstances = 10000
+
+// SrvInstances returns the valid instances, each as often as its weight
+// rounded up. It is empty if there is no valid instance with a positive weight.
+func (domain Domain) SrvInstances() []Instance {
+	// weights are clamped first, so that huge ones cannot add up to +Inf
+	max := float64(MaxSrvInstances)
+	weight := func(host Instance) float64 {
+		return math.Ceil(math.Min(host.Weight, max))
+	}
+
+	hosts := domain.Instances
+	total := 0.0
+	for _, host := range hosts {
+		if host.Valid && host.Weight > 0 {
+			total += weight(host)
+		}
+	}
+
+	scale := 1.0
+	if total > max {
+		scale = max / total
+	}
+
+	var result = make([]Instance, 0)
+	for _, host := range hosts {
+		if host.Valid && host.Weight > 0 {
+			// every instance keeps at least one entry, however small its share
+			n := int(math.Max(1, math.Ceil(weight(host)*scale)))
+			for i := 0; i < n; i++ {
+				result = append(result, host)
+			}
+		}
+	}
+
+	return result
+}
@@ -11,11 +11,10 @@
  * limitations under the License.
  */
 
-package nacos
+package nacoscache
 
 import (
 	"testing"
-	"strings"
 )
 
 func TestDomain_SrvInstances(t *testing.T) {
@@ -31,14 +30,18 @@ func TestDomain_SrvInstances(t *testing.T) {
 	}
 
 	//test valid
-	defer func() {
-		if err := recover(); err != nil {
-			if strings.HasPrefix(err.(string), "no host to srv: ") {
-				t.Log("Domain.srvInstances valid passed.")
-			}
-		}
-	}()
 	domain.Instances = []Instance{Instance{IP: "2.2.2.2", Port: 80, Weight: 2, AppUseType: "publish", Valid: false, Site: "et2"}}
-	domain.SrvInstances()
+	if instances := domain.SrvInstances(); len(instances) != 0 {
+		t.Fatalf("expected no instances, got %v", instances)
+	}
 
+	//test weights adding up to more than MaxSrvInstances
+	domain.Instances = []Instance{{IP: "2.2.2.2", Weight: 1e308, Valid: true}, {IP: "3.3.3.3", Weight: 1e308, Valid: true}, {IP: "4.4.4.4", Weight: 1, Valid: true}}
+	instances = domain.SrvInstances()
+	if len(instances) > MaxSrvInstances+len(domain.Instances) || len(instances) < MaxSrvInstances {
+		t.Fatalf("expected about %d instances, got %d", MaxSrvInstances, len(instances))
+	}
+	if instances[0].IP != "2.2.2.2" || instances[len(instances)-1].IP != "4.4.4.4" {
+		t.Fatal("expected every valid instance to be kept")
+	}
 }
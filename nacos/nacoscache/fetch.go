@@ -0,0 +1,120 @@
+/*
+ * Copyright 1999-2018 Alibaba Group Holding Ltd.
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *      http://www.apache.org/licenses/LICENSE-2.0
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package nacoscache
+
+import (
+	"context"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// separators of cache keys and service keys, like in the plugin
+const (
+	Separator          = "@@"
+	NamespaceSeparator = "##"
+)
+
+// DomainPath is the path of the API the instances of a dom are queried with.
+const DomainPath = "/nacos/v1/ns/api/srvIPXT"
+
+// SplitKey splits a cache key, dom@@clientIP, into dom and client IP. Doms
+// may contain Separator themselves, so only a trailing client IP is split off.
+func SplitKey(key string) (dom, clientIP string) {
+	i := strings.LastIndex(key, Separator)
+	if i < 0 {
+		return key, ""
+	}
+
+	clientIP = key[i+len(Separator):]
+	if clientIP != "" && net.ParseIP(clientIP) == nil {
+		return key, ""
+	}
+	return key[:i], clientIP
+}
+
+// ClusterDir is the dir in the default cache dir the plugin caches the doms
+// of the nacos servers at port in unless cache_dir is set, so the blocks of
+// different nacos clusters do not overwrite the files of each other.
+func ClusterDir(servers []string, port int) string {
+	sorted := append([]string(nil), servers...)
+	sort.Strings(sorted)
+	return strings.Join(sorted, ",") + "_" + strconv.Itoa(port)
+}
+
+// DomainParams adds the query for dom, a service key as
+// [namespace##][group@@]name, as seen by clientIP to params.
+func DomainParams(params map[string]string, dom, clientIP string) map[string]string {
+	if i := strings.Index(dom, NamespaceSeparator); i >= 0 {
+		params["namespaceId"] = dom[:i]
+		dom = dom[i+len(NamespaceSeparator):]
+	}
+	params["dom"] = dom
+
+	if clientIP != "" {
+		params["clientIP"] = clientIP
+	}
+	return params
+}
+
+// Getter sends a GET request with the query params to rawURL and returns
+// the body of the response.
+type Getter func(ctx context.Context, rawURL string, params map[string]string) (string, error)
+
+// Fetch queries the nacos server addr, host:port, for dom with get and
+// returns the response, which is what the cache files contain. The plugin
+// refreshes its cache with it, its getter traces and counts the requests.
+// params are sent along, without params no push port is announced to the
+// server. get defaults to Get.
+func Fetch(ctx context.Context, get Getter, addr string, params map[string]string, dom, clientIP string) (string, error) {
+	if get == nil {
+		get = Get
+	}
+	if params == nil {
+		params = map[string]string{"udpPort": "-1"}
+	}
+	return get(ctx, "http://"+addr+DomainPath, DomainParams(params, dom, clientIP))
+}
+
+// Get is a Getter with http.DefaultClient.
+func Get(ctx context.Context, rawURL string, params map[string]string) (string, error) {
+	query := url.Values{}
+	for k, v := range params {
+		query.Set(k, v)
+	}
+
+	req, err := http.NewRequest("GET", rawURL+"?"+query.Encode(), nil)
+	if err != nil {
+		return "", err
+	}
+	resp, err := http.DefaultClient.Do(req.WithContext(ctx))
+	if err != nil {
+		return "", err
+	}
+	defer resp.Body.Close()
+
+	b, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return "", err
+	}
+	if resp.StatusCode != http.StatusOK {
+		return "", fmt.Errorf("request to %s failed with code %d", req.URL.Host, resp.StatusCode)
+	}
+	return string(b), nil
+}
@@ -0,0 +1,65 @@
+/*
+ * Copyright 1999-2018 Alibaba Group Holding Ltd.
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *      http://www.apache.org/licenses/LICENSE-2.0
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package nacoscache
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestClusterDir(t *testing.T) {
+	dir := ClusterDir([]string{"10.0.0.2", "10.0.0.1"}, 8848)
+	if dir != "10.0.0.1,10.0.0.2_8848" {
+		t.Fatalf("unexpected dir %s", dir)
+	}
+	if ClusterDir([]string{"10.0.0.1", "10.0.0.2"}, 8848) != dir {
+		t.Fatal("expected the order of the servers not to matter")
+	}
+	if ClusterDir([]string{"10.0.0.1"}, 8848) == dir || ClusterDir([]string{"10.0.0.1", "10.0.0.2"}, 8849) == dir {
+		t.Fatal("expected other clusters to have other dirs")
+	}
+}
+
+func TestFetch(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		q := req.URL.Query()
+		if req.URL.Path != DomainPath || q.Get("dom") != "hello123" || q.Get("namespaceId") != "dev" || q.Get("udpPort") != "-1" {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		w.Write([]byte(`{"dom":"hello123"}`))
+	}))
+	defer server.Close()
+	addr := strings.TrimPrefix(server.URL, "http://")
+
+	if s, err := Fetch(context.Background(), nil, addr, nil, "dev##hello123", ""); err != nil || s != `{"dom":"hello123"}` {
+		t.Fatalf("unexpected response %q: %v", s, err)
+	}
+	if _, err := Fetch(context.Background(), nil, addr, nil, "hello123", ""); err == nil {
+		t.Fatal("expected an error for a failed request")
+	}
+
+	var params map[string]string
+	get := func(ctx context.Context, rawURL string, p map[string]string) (string, error) {
+		params = p
+		return rawURL, nil
+	}
+	s, _ := Fetch(context.Background(), get, "10.0.0.1:8848", map[string]string{"udpPort": "5353"}, "hello123", "10.0.0.5")
+	if s != "http://10.0.0.1:8848"+DomainPath || params["udpPort"] != "5353" || params["dom"] != "hello123" || params["clientIP"] != "10.0.0.5" {
+		t.Fatalf("unexpected request of the getter %s %v", s, params)
+	}
+}
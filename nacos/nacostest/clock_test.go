@@ -0,0 +1,60 @@
+/*
+ * Copyright 1999-2018 Alibaba Group Holding Ltd.
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *      http://www.apache.org/licenses/LICENSE-2.0
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package nacostest
+
+import (
+	"testing"
+	"time"
+)
+
+func TestClock(t *testing.T) {
+	start := time.Unix(1500000000, 0)
+	c := NewClock(start)
+
+	now := c.After(0)
+	second := c.After(time.Second)
+	minute := c.After(time.Minute)
+	if (<-now) != start || c.Waiters() != 2 {
+		t.Fatalf("expected After(0) to fire right away and two waiters, got %d", c.Waiters())
+	}
+
+	c.Advance(time.Second)
+	select {
+	case at := <-second:
+		if !at.Equal(start.Add(time.Second)) {
+			t.Fatalf("unexpected time %v", at)
+		}
+	default:
+		t.Fatal("expected After(time.Second) to fire")
+	}
+	select {
+	case <-minute:
+		t.Fatal("expected After(time.Minute) to wait")
+	default:
+	}
+
+	done := make(chan struct{})
+	go func() {
+		c.BlockUntil(2)
+		close(done)
+	}()
+	c.After(time.Hour)
+	<-done
+
+	c.Advance(time.Hour)
+	if c.Waiters() != 0 || !c.Now().Equal(start.Add(time.Hour+time.Second)) {
+		t.Fatalf("expected every waiter to fire, %d left at %v", c.Waiters(), c.Now())
+	}
+	<-minute
+}
@@ -0,0 +1,546 @@
+/*
+ * Copyright 1999-2018 Alibaba Group Holding Ltd.
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *      http://www.apache.org/licenses/LICENSE-2.0
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+// Package nacostest provides an in-process fake nacos server and a fake
+// clock for tests.
+//
+// The server serves the naming API the nacos plugin uses: srvIPXT, allDomNames,
+// the instance API with beats and the login of the auth API. Services are
+// keyed like in the plugin, as [namespace##][group@@]name. The package does
+// not import the plugin, so the tests of the plugin can use it.
+package nacostest
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	groupSeparator     = "@@"
+	namespaceSeparator = "##"
+	// namespace of the services registered without one
+	publicNamespace = "public"
+)
+
+// paths of the endpoints of Server
+const (
+	PathSrvIPXT     = "/nacos/v1/ns/api/srvIPXT"
+	PathAllDomNames = "/nacos/v1/ns/api/allDomNames"
+	PathInstance    = "/nacos/v1/ns/instance"
+	PathList        = "/nacos/v1/ns/instance/list"
+	PathBeat        = "/nacos/v1/ns/instance/beat"
+	PathLogin       = "/nacos/v1/auth/login"
+)
+
+// codes in the response to a beat
+const (
+	BeatOK               = 10200
+	BeatResourceNotFound = 20404
+)
+
+// Instance is an instance of a service.
+type Instance struct {
+	IP        string            `json:"ip"`
+	Port      int               `json:"port"`
+	Weight    float64           `json:"weight"`
+	Valid     bool              `json:"valid"`
+	Ephemeral bool              `json:"ephemeral"`
+	Metadata  map[string]string `json:"metadata"`
+}
+
+// domain is the response of srvIPXT and the data of a push.
+type domain struct {
+	Dom         string     `json:"dom"`
+	Clusters    string     `json:"clusters"`
+	CacheMillis int64      `json:"cacheMillis"`
+	LastRefTime int64      `json:"lastRefTime"`
+	Hosts       []Instance `json:"hosts"`
+	Env         string     `json:"env"`
+}
+
+// Server is a fake nacos server. Create it with NewServer and Close it
+// when done. All methods are safe for concurrent use.
+type Server struct {
+	// URL is http://host:port of the HTTP API.
+	URL string
+
+	// CacheMillis is sent in srvIPXT and allDomNames responses.
+	CacheMillis int64
+	// LegacyDomNames serves allDomNames as a plain list like nacos before
+	// 1.2, instead of a list per namespace.
+	LegacyDomNames bool
+	// PushTimeout is how long Push waits for acks.
+	PushTimeout time.Duration
+
+	http *httptest.Server
+	conn *net.UDPConn
+
+	mu       sync.Mutex
+	services map[string][]Instance
+	latency  time.Duration
+	failures map[string]int
+	requests map[string]int
+	// push addresses of the clients by service key
+	subscribers map[string]map[string]*net.UDPAddr
+	username    string
+	password    string
+	tokens      map[string]bool
+	refTime     int64
+	// acks still expected by the pushes in flight, by lastRefTime
+	pending map[int64]chan struct{}
+}
+
+// NewServer starts a fake nacos server on a random local port.
+func NewServer() *Server {
+	conn, err := net.ListenUDP("udp", &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1)})
+	if err != nil {
+		panic("nacostest: failed to listen for push acks: " + err.Error())
+	}
+
+	s := &Server{
+		CacheMillis: 10000,
+		PushTimeout: time.Second,
+		conn:        conn,
+		services:    make(map[string][]Instance),
+		failures:    make(map[string]int),
+		requests:    make(map[string]int),
+		subscribers: make(map[string]map[string]*net.UDPAddr),
+		tokens:      make(map[string]bool),
+		pending:     make(map[int64]chan struct{}),
+	}
+	s.http = httptest.NewServer(http.HandlerFunc(s.serveHTTP))
+	s.URL = s.http.URL
+	go s.readAcks()
+	return s
+}
+
+// Close stops the server.
+func (s *Server) Close() {
+	s.http.Close()
+	s.conn.Close()
+}
+
+// Host returns the host of the HTTP API, as given to the client as a server.
+func (s *Server) Host() string {
+	host, _, _ := net.SplitHostPort(strings.TrimPrefix(s.URL, "http://"))
+	return host
+}
+
+// Port returns the port of the HTTP API.
+func (s *Server) Port() int {
+	_, port, _ := net.SplitHostPort(strings.TrimPrefix(s.URL, "http://"))
+	p, _ := strconv.Atoi(port)
+	return p
+}
+
+// SetService registers key with the given instances, replacing any it had.
+func (s *Server) SetService(key string, instances ...Instance) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.services[key] = append([]Instance(nil), instances...)
+}
+
+// RemoveService removes key and its instances.
+func (s *Server) RemoveService(key string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	delete(s.services, key)
+}
+
+// Instances returns the instances of key.
+func (s *Server) Instances(key string) []Instance {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return append([]Instance(nil), s.services[key]...)
+}
+
+// Services returns the registered keys, sorted.
+func (s *Server) Services() []string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	keys := make([]string, 0, len(s.services))
+	for key := range s.services {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+// SetLatency delays every response by d.
+func (s *Server) SetLatency(d time.Duration) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.latency = d
+}
+
+// SetFailure answers every request to path with the HTTP status code, 0 clears the failure.
+func (s *Server) SetFailure(path string, code int) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if code == 0 {
+		delete(s.failures, path)
+		return
+	}
+	s.failures[path] = code
+}
+
+// Requests returns how many requests were made to path, including failed ones.
+func (s *Server) Requests(path string) int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.requests[path]
+}
+
+// EnableAuth requires an accessToken, as returned by the login endpoint
+// for username and password, in every other request.
+func (s *Server) EnableAuth(username, password string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.username, s.password = username, password
+}
+
+// Subscribers returns the push addresses of the clients that queried key
+// with a push port.
+func (s *Server) Subscribers(key string) []string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	addrs := make([]string, 0)
+	for addr := range s.subscribers[key] {
+		addrs = append(addrs, addr)
+	}
+	sort.Strings(addrs)
+	return addrs
+}
+
+// Push sends the instances of key to its subscribers and returns how many
+// acked within PushTimeout.
+func (s *Server) Push(key string) (int, error) {
+	s.mu.Lock()
+	s.refTime++
+	refTime := s.refTime
+	data, _ := json.Marshal(s.domain(key, refTime))
+	addrs := make([]*net.UDPAddr, 0)
+	for _, addr := range s.subscribers[key] {
+		addrs = append(addrs, addr)
+	}
+	acks := make(chan struct{}, len(addrs))
+	s.pending[refTime] = acks
+	s.mu.Unlock()
+
+	defer func() {
+		s.mu.Lock()
+		delete(s.pending, refTime)
+		s.mu.Unlock()
+	}()
+
+	push, _ := json.Marshal(map[string]interface{}{"type": "dom", "data": string(data), "lastRefTime": refTime})
+	for _, addr := range addrs {
+		if _, err := s.conn.WriteToUDP(push, addr); err != nil {
+			return 0, err
+		}
+	}
+
+	acked := 0
+	timeout := time.After(s.PushTimeout)
+	for acked < len(addrs) {
+		select {
+		case <-acks:
+			acked++
+		case <-timeout:
+			return acked, nil
+		}
+	}
+	return acked, nil
+}
+
+func (s *Server) readAcks() {
+	buf := make([]byte, 4096)
+	for {
+		n, _, err := s.conn.ReadFromUDP(buf)
+		if err != nil {
+			return
+		}
+
+		// the client sends lastRefTime as a string
+		var ack struct {
+			Type        string      `json:"type"`
+			LastRefTime json.Number `json:"lastRefTime"`
+		}
+		if json.Unmarshal(buf[:n], &ack) != nil || ack.Type != "push-ack" {
+			continue
+		}
+		refTime, err := ack.LastRefTime.Int64()
+		if err != nil {
+			continue
+		}
+
+		s.mu.Lock()
+		if acks, ok := s.pending[refTime]; ok {
+			select {
+			case acks <- struct{}{}:
+			default:
+			}
+		}
+		s.mu.Unlock()
+	}
+}
+
+// serviceKey returns the key of the service a request is about.
+func serviceKey(name, group, namespace string) string {
+	if group != "" && !strings.Contains(name, groupSeparator) {
+		name = group + groupSeparator + name
+	}
+	if namespace != "" && namespace != publicNamespace {
+		name = namespace + namespaceSeparator + name
+	}
+	return name
+}
+
+// splitKey returns the namespace and the grouped name of a key.
+func splitKey(key string) (namespace, name string) {
+	if i := strings.Index(key, namespaceSeparator); i >= 0 {
+		return key[:i], key[i+len(namespaceSeparator):]
+	}
+	return "", key
+}
+
+func (s *Server) domain(key string, refTime int64) domain {
+	_, name := splitKey(key)
+	hosts := s.services[key]
+	if hosts == nil {
+		hosts = []Instance{}
+	}
+	return domain{Dom: name, CacheMillis: s.CacheMillis, LastRefTime: refTime, Hosts: hosts}
+}
+
+func (s *Server) serveHTTP(w http.ResponseWriter, r *http.Request) {
+	r.ParseForm()
+	path := r.URL.Path
+
+	s.mu.Lock()
+	s.requests[path]++
+	latency, code := s.latency, s.failures[path]
+	authorized := s.username == "" || path == PathLogin || s.tokens[r.Form.Get("accessToken")]
+	s.mu.Unlock()
+
+	if latency > 0 {
+		select {
+		case <-time.After(latency):
+		case <-r.Context().Done():
+			return
+		}
+	}
+	if code != 0 {
+		http.Error(w, "injected failure", code)
+		return
+	}
+	if !authorized {
+		http.Error(w, "unknown user!", http.StatusForbidden)
+		return
+	}
+
+	switch {
+	case path == PathSrvIPXT && r.Method == "GET":
+		s.srvIPXT(w, r)
+	case path == PathAllDomNames && r.Method == "GET":
+		s.allDomNames(w)
+	case path == PathList && r.Method == "GET":
+		s.list(w, r)
+	case path == PathInstance && r.Method == "POST":
+		s.register(w, r)
+	case path == PathInstance && r.Method == "DELETE":
+		s.deregister(w, r)
+	case path == PathBeat && r.Method == "PUT":
+		s.beat(w, r)
+	case path == PathLogin && r.Method == "POST":
+		s.login(w, r)
+	default:
+		http.NotFound(w, r)
+	}
+}
+
+func writeJSON(w http.ResponseWriter, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(v)
+}
+
+func (s *Server) srvIPXT(w http.ResponseWriter, r *http.Request) {
+	key := serviceKey(r.Form.Get("dom"), "", r.Form.Get("namespaceId"))
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	// like nacos, clients announcing a push port are pushed to on changes
+	if port, err := strconv.Atoi(r.Form.Get("udpPort")); err == nil && port > 0 {
+		host, _, _ := net.SplitHostPort(r.RemoteAddr)
+		addr := &net.UDPAddr{IP: net.ParseIP(host), Port: port}
+		if s.subscribers[key] == nil {
+			s.subscribers[key] = make(map[string]*net.UDPAddr)
+		}
+		s.subscribers[key][addr.String()] = addr
+	}
+	writeJSON(w, s.domain(key, time.Now().UnixNano()/int64(time.Millisecond)))
+}
+
+func (s *Server) allDomNames(w http.ResponseWriter) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.LegacyDomNames {
+		doms := make([]string, 0)
+		for key := range s.services {
+			doms = append(doms, key)
+		}
+		sort.Strings(doms)
+		writeJSON(w, map[string]interface{}{"count": len(doms), "doms": doms, "cacheMillis": s.CacheMillis})
+		return
+	}
+
+	doms := make(map[string][]string)
+	for key := range s.services {
+		namespace, name := splitKey(key)
+		if namespace == "" {
+			namespace = publicNamespace
+		}
+		doms[namespace] = append(doms[namespace], name)
+	}
+	for _, names := range doms {
+		sort.Strings(names)
+	}
+	writeJSON(w, map[string]interface{}{"count": len(s.services), "doms": doms, "cacheMillis": s.CacheMillis})
+}
+
+func (s *Server) requestKey(r *http.Request) string {
+	return serviceKey(r.Form.Get("serviceName"), r.Form.Get("groupName"), r.Form.Get("namespaceId"))
+}
+
+func (s *Server) list(w http.ResponseWriter, r *http.Request) {
+	key := s.requestKey(r)
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	d := s.domain(key, time.Now().UnixNano()/int64(time.Millisecond))
+	if r.Form.Get("healthyOnly") == "true" {
+		healthy := make([]Instance, 0)
+		for _, instance := range d.Hosts {
+			if instance.Valid {
+				healthy = append(healthy, instance)
+			}
+		}
+		d.Hosts = healthy
+	}
+	writeJSON(w, d)
+}
+
+// instance returns the instance a request is about and its index in the
+// instances of key, or -1.
+func (s *Server) instance(r *http.Request, key string) (Instance, int, error) {
+	port, err := strconv.Atoi(r.Form.Get("port"))
+	if err != nil {
+		return Instance{}, -1, err
+	}
+	instance := Instance{IP: r.Form.Get("ip"), Port: port, Weight: 1, Valid: true, Ephemeral: r.Form.Get("ephemeral") != "false"}
+	if net.ParseIP(instance.IP) == nil {
+		return Instance{}, -1, &net.ParseError{Type: "IP address", Text: instance.IP}
+	}
+
+	for i, existing := range s.services[key] {
+		if existing.IP == instance.IP && existing.Port == instance.Port {
+			return instance, i, nil
+		}
+	}
+	return instance, -1, nil
+}
+
+func (s *Server) register(w http.ResponseWriter, r *http.Request) {
+	key := s.requestKey(r)
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	instance, i, err := s.instance(r, key)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if weight := r.Form.Get("weight"); weight != "" {
+		if instance.Weight, err = strconv.ParseFloat(weight, 64); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+	}
+	if metadata := r.Form.Get("metadata"); metadata != "" {
+		if err := json.Unmarshal([]byte(metadata), &instance.Metadata); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+	}
+
+	if i >= 0 {
+		s.services[key][i] = instance
+	} else {
+		s.services[key] = append(s.services[key], instance)
+	}
+	w.Write([]byte("ok"))
+}
+
+func (s *Server) deregister(w http.ResponseWriter, r *http.Request) {
+	key := s.requestKey(r)
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	_, i, err := s.instance(r, key)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if i >= 0 {
+		instances := s.services[key]
+		s.services[key] = append(instances[:i:i], instances[i+1:]...)
+	}
+	w.Write([]byte("ok"))
+}
+
+func (s *Server) beat(w http.ResponseWriter, r *http.Request) {
+	key := s.requestKey(r)
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if _, i, err := s.instance(r, key); err != nil || i < 0 {
+		writeJSON(w, map[string]interface{}{"code": BeatResourceNotFound})
+		return
+	}
+	writeJSON(w, map[string]interface{}{"code": BeatOK, "clientBeatInterval": 5000})
+}
+
+func (s *Server) login(w http.ResponseWriter, r *http.Request) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.username == "" || r.Form.Get("username") != s.username || r.Form.Get("password") != s.password {
+		http.Error(w, "unknown user!", http.StatusForbidden)
+		return
+	}
+
+	b := make([]byte, 16)
+	rand.Read(b)
+	token := hex.EncodeToString(b)
+	s.tokens[token] = true
+	writeJSON(w, map[string]interface{}{"accessToken": token, "tokenTtl": 18000, "globalAdmin": true})
+}
@@ -0,0 +1,205 @@
+/*
+ * Copyright 1999-2018 Alibaba Group Holding Ltd.
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *      http://www.apache.org/licenses/LICENSE-2.0
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package nacostest
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/url"
+	"reflect"
+	"strconv"
+	"testing"
+	"time"
+)
+
+func request(t *testing.T, s *Server, method, path string, params url.Values) (int, string) {
+	req, err := http.NewRequest(method, s.URL+path+"?"+params.Encode(), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	b, _ := ioutil.ReadAll(resp.Body)
+	return resp.StatusCode, string(b)
+}
+
+func TestServer_Services(t *testing.T) {
+	s := NewServer()
+	defer s.Close()
+
+	s.SetService("DEFAULT_GROUP@@hello123", Instance{IP: "2.2.2.2", Port: 80, Weight: 1, Valid: true})
+	s.SetService("dev##world456")
+
+	code, body := request(t, s, "GET", PathSrvIPXT, url.Values{"dom": {"DEFAULT_GROUP@@hello123"}})
+	var d domain
+	if code != http.StatusOK || json.Unmarshal([]byte(body), &d) != nil || d.Dom != "DEFAULT_GROUP@@hello123" || len(d.Hosts) != 1 || d.Hosts[0].IP != "2.2.2.2" {
+		t.Fatalf("unexpected srvIPXT response %d %s", code, body)
+	}
+
+	_, body = request(t, s, "GET", PathAllDomNames, nil)
+	var names struct {
+		Doms map[string][]string `json:"doms"`
+	}
+	json.Unmarshal([]byte(body), &names)
+	expected := map[string][]string{"public": {"DEFAULT_GROUP@@hello123"}, "dev": {"world456"}}
+	if !reflect.DeepEqual(names.Doms, expected) {
+		t.Fatalf("unexpected allDomNames response %s", body)
+	}
+
+	s.LegacyDomNames = true
+	_, body = request(t, s, "GET", PathAllDomNames, nil)
+	var legacy struct {
+		Doms []string `json:"doms"`
+	}
+	json.Unmarshal([]byte(body), &legacy)
+	if !reflect.DeepEqual(legacy.Doms, []string{"DEFAULT_GROUP@@hello123", "dev##world456"}) {
+		t.Fatalf("unexpected legacy allDomNames response %s", body)
+	}
+}
+
+func TestServer_Instances(t *testing.T) {
+	s := NewServer()
+	defer s.Close()
+
+	params := url.Values{"serviceName": {"web"}, "groupName": {"DNS"}, "namespaceId": {"dev"}, "ip": {"10.0.0.1"}, "port": {"8080"}}
+	if code, body := request(t, s, "PUT", PathBeat, params); code != http.StatusOK || body != `{"code":20404}`+"\n" {
+		t.Fatalf("expected beat of an unknown instance to fail, got %d %s", code, body)
+	}
+
+	params.Set("weight", "2")
+	params.Set("ephemeral", "false")
+	params.Set("metadata", `{"version":"1.0"}`)
+	if code, _ := request(t, s, "POST", PathInstance, params); code != http.StatusOK {
+		t.Fatalf("expected register to succeed, got %d", code)
+	}
+	expected := []Instance{{IP: "10.0.0.1", Port: 8080, Weight: 2, Valid: true, Metadata: map[string]string{"version": "1.0"}}}
+	if instances := s.Instances("dev##DNS@@web"); !reflect.DeepEqual(instances, expected) {
+		t.Fatalf("unexpected instances %+v", instances)
+	}
+	if code, body := request(t, s, "PUT", PathBeat, params); code != http.StatusOK || body != `{"clientBeatInterval":5000,"code":10200}`+"\n" {
+		t.Fatalf("expected beat to succeed, got %d %s", code, body)
+	}
+
+	_, body := request(t, s, "GET", PathList, url.Values{"serviceName": {"DNS@@web"}, "namespaceId": {"dev"}})
+	var d domain
+	if json.Unmarshal([]byte(body), &d) != nil || len(d.Hosts) != 1 {
+		t.Fatalf("unexpected list response %s", body)
+	}
+
+	if code, _ := request(t, s, "DELETE", PathInstance, params); code != http.StatusOK || len(s.Instances("dev##DNS@@web")) != 0 {
+		t.Fatalf("expected deregister to remove the instance, got %d %v", code, s.Instances("dev##DNS@@web"))
+	}
+	if s.Requests(PathInstance) != 2 || s.Requests(PathBeat) != 2 {
+		t.Fatalf("unexpected request counts %d %d", s.Requests(PathInstance), s.Requests(PathBeat))
+	}
+}
+
+func TestServer_FailuresAndLatency(t *testing.T) {
+	s := NewServer()
+	defer s.Close()
+
+	s.SetFailure(PathSrvIPXT, http.StatusServiceUnavailable)
+	if code, _ := request(t, s, "GET", PathSrvIPXT, url.Values{"dom": {"hello123"}}); code != http.StatusServiceUnavailable {
+		t.Fatalf("expected injected failure, got %d", code)
+	}
+	s.SetFailure(PathSrvIPXT, 0)
+	if code, _ := request(t, s, "GET", PathSrvIPXT, url.Values{"dom": {"hello123"}}); code != http.StatusOK {
+		t.Fatalf("expected failure to be cleared, got %d", code)
+	}
+
+	s.SetLatency(50 * time.Millisecond)
+	start := time.Now()
+	request(t, s, "GET", PathAllDomNames, nil)
+	if time.Since(start) < 50*time.Millisecond {
+		t.Fatal("expected the response to be delayed")
+	}
+}
+
+func TestServer_Auth(t *testing.T) {
+	s := NewServer()
+	defer s.Close()
+	s.EnableAuth("nacos", "secret")
+
+	if code, _ := request(t, s, "GET", PathAllDomNames, nil); code != http.StatusForbidden {
+		t.Fatalf("expected request without token to be forbidden, got %d", code)
+	}
+	if code, _ := request(t, s, "POST", PathLogin, url.Values{"username": {"nacos"}, "password": {"wrong"}}); code != http.StatusForbidden {
+		t.Fatalf("expected login with a wrong password to fail, got %d", code)
+	}
+
+	_, body := request(t, s, "POST", PathLogin, url.Values{"username": {"nacos"}, "password": {"secret"}})
+	var login struct {
+		AccessToken string `json:"accessToken"`
+	}
+	json.Unmarshal([]byte(body), &login)
+	if code, _ := request(t, s, "GET", PathAllDomNames, url.Values{"accessToken": {login.AccessToken}}); code != http.StatusOK {
+		t.Fatalf("expected request with token to succeed, got %d", code)
+	}
+}
+
+func TestServer_Push(t *testing.T) {
+	s := NewServer()
+	defer s.Close()
+	s.PushTimeout = 100 * time.Millisecond
+	s.SetService("hello123", Instance{IP: "2.2.2.2", Port: 80, Weight: 1, Valid: true})
+
+	// a client that acks every push
+	conn, err := net.ListenUDP("udp", &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	pushes := make(chan string, 1)
+	go func() {
+		buf := make([]byte, 4096)
+		for {
+			n, addr, err := conn.ReadFromUDP(buf)
+			if err != nil {
+				return
+			}
+			var push struct {
+				Data        string `json:"data"`
+				LastRefTime int64  `json:"lastRefTime"`
+			}
+			json.Unmarshal(buf[:n], &push)
+			ack, _ := json.Marshal(map[string]string{"type": "push-ack", "lastRefTime": strconv.FormatInt(push.LastRefTime, 10), "data": ""})
+			conn.WriteToUDP(ack, addr)
+			pushes <- push.Data
+		}
+	}()
+
+	if acked, err := s.Push("hello123"); err != nil || acked != 0 {
+		t.Fatalf("expected no subscribers, got %d %v", acked, err)
+	}
+
+	udpPort := conn.LocalAddr().(*net.UDPAddr).Port
+	request(t, s, "GET", PathSrvIPXT, url.Values{"dom": {"hello123"}, "udpPort": {strconv.Itoa(udpPort)}})
+	if subscribers := s.Subscribers("hello123"); len(subscribers) != 1 {
+		t.Fatalf("expected the client to subscribe, got %v", subscribers)
+	}
+
+	s.SetService("hello123", Instance{IP: "3.3.3.3", Port: 80, Weight: 1, Valid: true})
+	if acked, err := s.Push("hello123"); err != nil || acked != 1 {
+		t.Fatalf("expected the push to be acked, got %d %v", acked, err)
+	}
+	var d domain
+	if json.Unmarshal([]byte(<-pushes), &d) != nil || d.Hosts[0].IP != "3.3.3.3" {
+		t.Fatalf("unexpected push %+v", d)
+	}
+}
@@ -0,0 +1,122 @@
+/*
+ * Copyright 1999-2018 Alibaba Group Holding Ltd.
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *      http://www.apache.org/licenses/LICENSE-2.0
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package nacos
+
+import (
+	"regexp"
+	"strings"
+)
+
+// separates the namespace from the rest of a service key
+var NAMESPACE_SEPERATOR = "##"
+
+// Service identifies a service in nacos.
+type Service struct {
+	Name      string
+	Group     string
+	Namespace string
+}
+
+// GroupedName returns the name of the service as nacos knows it in its namespace.
+func (s Service) GroupedName() string {
+	if s.Group == "" {
+		return s.Name
+	}
+	return s.Group + SEPERATOR + s.Name
+}
+
+// Key returns the dom the service is cached under, [namespace##][group@@]name.
+func (s Service) Key() string {
+	if s.Namespace == "" {
+		return s.GroupedName()
+	}
+	return s.Namespace + NAMESPACE_SEPERATOR + s.GroupedName()
+}
+
+// ParseServiceKey is the reverse of Service.Key.
+func ParseServiceKey(key string) Service {
+	var s Service
+	if i := strings.Index(key, NAMESPACE_SEPERATOR); i >= 0 {
+		s.Namespace = key[:i]
+		key = key[i+len(NAMESPACE_SEPERATOR):]
+	}
+	if i := strings.Index(key, SEPERATOR); i >= 0 {
+		s.Group = key[:i]
+		key = key[i+len(SEPERATOR):]
+	}
+	s.Name = key
+	return s
+}
+
+// NameRule maps DNS names matching Pattern to a service. Service, Group
+// and Namespace are templates that may refer to the groups of Pattern,
+// e.g. $1 or ${version}.
+type NameRule struct {
+	Pattern   *regexp.Regexp
+	Service   string
+	Group     string
+	Namespace string
+}
+
+// ServiceRule maps services whose key matches Pattern to a DNS name.
+// Name is a template that may refer to the groups of Pattern.
+type ServiceRule struct {
+	Pattern *regexp.Regexp
+	Name    string
+}
+
+// NameMapper translates DNS names to services and back. The first
+// matching rule wins, names no rule matches are used as they are.
+type NameMapper struct {
+	NameRules    []NameRule
+	ServiceRules []ServiceRule
+}
+
+// ToService returns the service of a DNS name without trailing dot.
+// Rules are expected to match case insensitively, the groups keep the case
+// of the name since service names are case sensitive.
+func (m NameMapper) ToService(name string) (Service, bool) {
+	for _, rule := range m.NameRules {
+		match := rule.Pattern.FindStringSubmatchIndex(name)
+		if match == nil {
+			continue
+		}
+
+		expand := func(template string) string {
+			return string(rule.Pattern.ExpandString(nil, template, name, match))
+		}
+		return Service{Name: expand(rule.Service), Group: expand(rule.Group), Namespace: expand(rule.Namespace)}, true
+	}
+
+	return Service{Name: name}, false
+}
+
+// ToName returns the DNS name without trailing dot of a service.
+func (m NameMapper) ToName(s Service) (string, bool) {
+	key := s.Key()
+	for _, rule := range m.ServiceRules {
+		match := rule.Pattern.FindStringSubmatchIndex(key)
+		if match == nil {
+			continue
+		}
+		return string(rule.Pattern.ExpandString(nil, rule.Name, key, match)), true
+	}
+
+	return key, false
+}
+
+// HasServiceRules reports whether services can be mapped back to DNS names.
+func (m NameMapper) HasServiceRules() bool {
+	return len(m.ServiceRules) > 0
+}
@@ -0,0 +1,78 @@
+/*
+ * Copyright 1999-2018 Alibaba Group Holding Ltd.
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *      http://www.apache.org/licenses/LICENSE-2.0
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package nacos
+
+import (
+	"regexp"
+	"testing"
+)
+
+func dubboMapper() NameMapper {
+	return NameMapper{
+		NameRules: []NameRule{
+			{Pattern: regexp.MustCompile(`(?i)^(.+)\.v([0-9.]+)\.dubbo$`), Service: "providers:$1:$2", Group: "DEFAULT_GROUP", Namespace: "dev"},
+		},
+		ServiceRules: []ServiceRule{
+			{Pattern: regexp.MustCompile(`^dev##DEFAULT_GROUP@@providers:(.+):([0-9.]+)$`), Name: "$1.v$2.dubbo"},
+		},
+	}
+}
+
+func TestNameMapper(t *testing.T) {
+	m := dubboMapper()
+
+	service, ok := m.ToService("com.foo.Bar.v1.0.DUBBO")
+	if !ok {
+		t.Fatal("expected name to match")
+	}
+	expected := Service{Name: "providers:com.foo.Bar:1.0", Group: "DEFAULT_GROUP", Namespace: "dev"}
+	if service != expected {
+		t.Fatalf("expected %+v, got %+v", expected, service)
+	}
+	if key := service.Key(); key != "dev##DEFAULT_GROUP@@providers:com.foo.Bar:1.0" {
+		t.Fatalf("unexpected key %s", key)
+	}
+	if ParseServiceKey(service.Key()) != service {
+		t.Fatalf("expected %s to parse back to %+v", service.Key(), service)
+	}
+
+	if name, ok := m.ToName(service); !ok || name != "com.foo.Bar.v1.0.dubbo" {
+		t.Fatalf("unexpected name %s", name)
+	}
+
+	if service, ok := m.ToService("Hello123"); ok || service.Key() != "Hello123" {
+		t.Fatalf("expected unmatched name to be used as it is, got %+v", service)
+	}
+	if _, ok := m.ToName(Service{Name: "hello123"}); ok {
+		t.Fatal("expected unmatched service to have no name")
+	}
+}
+
+func TestSplitCacheKey(t *testing.T) {
+	tests := []struct {
+		key, dom, clientIP string
+	}{
+		{"hello123", "hello123", ""},
+		{"hello123@@", "hello123", ""},
+		{"hello123@@10.0.0.1", "hello123", "10.0.0.1"},
+		{"DEFAULT_GROUP@@hello123@@10.0.0.1", "DEFAULT_GROUP@@hello123", "10.0.0.1"},
+		{"DEFAULT_GROUP@@hello123", "DEFAULT_GROUP@@hello123", ""},
+	}
+
+	for _, test := range tests {
+		if dom, clientIP := SplitCacheKey(test.key); dom != test.dom || clientIP != test.clientIP {
+			t.Errorf("expected %s to split into %s and %s, got %s and %s", test.key, test.dom, test.clientIP, dom, clientIP)
+		}
+	}
+}
@@ -0,0 +1,125 @@
+/*
+ * Copyright 1999-2018 Alibaba Group Holding Ltd.
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *      http://www.apache.org/licenses/LICENSE-2.0
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package nacos
+
+import (
+	"bufio"
+	"context"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+var (
+	DefaultPrefetchTimeout = 10 * time.Second
+	PrefetchWorkers        = 16
+)
+
+// ReadPrefetchFile reads dom names from path, one per line.
+// Blank lines and lines starting with '#' are skipped.
+func ReadPrefetchFile(path string) ([]string, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	var doms []string
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		doms = append(doms, line)
+	}
+
+	return doms, scanner.Err()
+}
+
+// Prefetch loads doms into the domain cache before the first query arrives.
+// An empty list prefetches every dom registered in nacos. Doms are fetched
+// concurrently and Prefetch returns once all of them are loaded or timeout
+// has passed, whichever comes first. It returns the number of doms loaded.
+// Doms left after the timeout are loaded in background until Stop is called.
+func (vc *NacosClient) Prefetch(doms []string, timeout time.Duration) int {
+	if len(doms) == 0 {
+		doms = vc.AllDomNames()
+	}
+
+	if len(doms) == 0 {
+		return 0
+	}
+
+	ctx := vc.ctx
+	if ctx == nil {
+		ctx = context.Background()
+	}
+	clientIP := LocalIP()
+	start := time.Now()
+
+	var loaded int
+	var mu sync.Mutex
+	var wg sync.WaitGroup
+
+	jobs := make(chan string, len(doms))
+	for _, dom := range doms {
+		jobs <- dom
+	}
+	close(jobs)
+
+	workers := PrefetchWorkers
+	if workers > len(doms) {
+		workers = len(doms)
+	}
+
+	wg.Add(workers)
+	for i := 0; i < workers; i++ {
+		go func() {
+			defer wg.Done()
+			for dom := range jobs {
+				if ctx.Err() != nil {
+					return
+				}
+				domain := vc.getDomNow(ctx, dom, &vc.domainMap, clientIP)
+				if len(domain.Instances) > 0 {
+					mu.Lock()
+					loaded++
+					mu.Unlock()
+				}
+			}
+		}()
+	}
+
+	done := make(chan struct{})
+	go func() {
+		wg.Wait()
+		close(done)
+	}()
+
+	select {
+	case <-done:
+	case <-ctx.Done():
+	case <-time.After(timeout):
+		vc.Logger().Warn("prefetch timed out after " + timeout.String() + ", remaining doms are loaded in background")
+	}
+
+	mu.Lock()
+	defer mu.Unlock()
+	vc.Logger().Info("prefetched " + strconv.Itoa(loaded) + "/" + strconv.Itoa(len(doms)) +
+		" doms in " + time.Since(start).String())
+	return loaded
+}
@@ -0,0 +1,73 @@
+/*
+ * Copyright 1999-2018 Alibaba Group Holding Ltd.
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *      http://www.apache.org/licenses/LICENSE-2.0
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package nacos
+
+import (
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestNacosClient_Prefetch(t *testing.T) {
+	s := `{"dom":"%s","cacheMillis":10000,"useSpecifiedURL":false,"hosts":[{"valid":true,"marked":false,"metadata":{},"instanceId":"","port":81,"ip":"2.2.2.2","weight":1.0,"enabled":true}],"checksum":"c7befb32f3bb5b169f76efbb0e1f79eb1542236821437","lastRefTime":1542236821437,"env":"","clusters":""}`
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		if req.URL.EscapedPath() == "/nacos/v1/ns/api/srvIPXT" {
+			w.WriteHeader(http.StatusOK)
+			w.Write([]byte(strings.Replace(s, "%s", req.URL.Query().Get("dom"), 1)))
+		}
+	}))
+	defer server.Close()
+
+	port, _ := strconv.Atoi(strings.Split(server.URL, ":")[2])
+
+	vc := NacosClient{domainMap: NewConcurrentMap(), cachePath: t.TempDir(), serverPort: port}
+	vc.udpServer.vipClient = &vc
+	vc.SetServers([]string{strings.Split(strings.Split(server.URL, "http://")[1], ":")[0]})
+
+	loaded := vc.Prefetch([]string{"hello1", "hello2", "hello3"}, time.Second)
+	if loaded != 3 {
+		t.Fatalf("expected 3 prefetched doms, got %d", loaded)
+	}
+
+	for _, dom := range []string{"hello1", "hello2", "hello3"} {
+		if _, ok := vc.GetDomainCache().Get(GetCacheKey(dom, LocalIP())); !ok {
+			t.Fatalf("dom %s is not in cache after prefetch", dom)
+		}
+	}
+}
+
+func TestReadPrefetchFile(t *testing.T) {
+	f, err := ioutil.TempFile("", "nacos-prefetch")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.Remove(f.Name())
+
+	f.WriteString("# services to warm up\nhello1\n\n  hello2  \n")
+	f.Close()
+
+	doms, err := ReadPrefetchFile(f.Name())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(doms) != 2 || doms[0] != "hello1" || doms[1] != "hello2" {
+		t.Fatalf("unexpected doms: %v", doms)
+	}
+}
@@ -0,0 +1,206 @@
+/*
+ * Copyright 1999-2018 Alibaba Group Holding Ltd.
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *      http://www.apache.org/licenses/LICENSE-2.0
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package nacos
+
+import (
+	"context"
+	"encoding/json"
+	"strconv"
+	"sync"
+	"time"
+)
+
+var (
+	// service the agent registers itself as if the register directive has no service
+	DefaultRegisterService = "nacos-coredns"
+	DefaultBeatInterval    = 5 * time.Second
+)
+
+// code of a beat for an instance nacos does not know, e.g. after it expired
+const beatResourceNotFound = 20404
+
+// Registrar registers an instance in nacos and keeps it alive with beats.
+type Registrar struct {
+	client   *NacosClient
+	Service  Service
+	IP       string
+	Port     int
+	Metadata map[string]string
+	Interval time.Duration
+
+	cancel context.CancelFunc
+	wg     sync.WaitGroup
+}
+
+// NewRegistrar returns a registrar for an instance on ip:port, nothing is
+// registered until Start is called.
+func NewRegistrar(client *NacosClient, service Service, ip string, port int) *Registrar {
+	return &Registrar{client: client, Service: service, IP: ip, Port: port,
+		Metadata: make(map[string]string), Interval: DefaultBeatInterval}
+}
+
+// Start registers the instance and sends beats until Stop is called. Beats
+// are sent even if the registration fails, they register the instance again.
+func (r *Registrar) Start() {
+	ctx, cancel := context.WithCancel(context.Background())
+	r.cancel = cancel
+
+	if err := r.Register(ctx); err != nil {
+		r.client.Logger().Warn("failed to register "+r.Service.Key()+", retrying with the next beat: ", err)
+	}
+
+	r.wg.Add(1)
+	go func() {
+		defer r.wg.Done()
+		r.beatLoop(ctx)
+	}()
+}
+
+// Stop stops the beats and deregisters the instance.
+func (r *Registrar) Stop() error {
+	return r.stop(true)
+}
+
+// stop is Stop, the instance stays registered until it expires in nacos
+// unless deregister is set.
+func (r *Registrar) stop(deregister bool) error {
+	if r.cancel == nil {
+		return nil
+	}
+	r.cancel()
+	r.wg.Wait()
+	r.cancel = nil
+
+	if !deregister {
+		return nil
+	}
+	return r.Deregister(context.Background())
+}
+
+func (r *Registrar) beatLoop(ctx context.Context) {
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-r.client.Clock().After(r.Interval):
+		}
+
+		if err := r.Beat(ctx); err != nil {
+			r.client.Logger().Warn("failed to send beat of "+r.Service.Key()+": ", err)
+		}
+	}
+}
+
+// instanceParams returns the parameters identifying an instance in the instance API of nacos.
+func instanceParams(service Service, ip string, port int, ephemeral bool) map[string]string {
+	params := make(map[string]string)
+	params["serviceName"] = service.GroupedName()
+	if service.Group != "" {
+		params["groupName"] = service.Group
+	}
+	if service.Namespace != "" {
+		params["namespaceId"] = service.Namespace
+	}
+	params["ip"] = ip
+	params["port"] = strconv.Itoa(port)
+	params["ephemeral"] = strconv.FormatBool(ephemeral)
+	return params
+}
+
+func (vc *NacosClient) instanceURL(path string) string {
+	return "http://" + vc.serverManager.NextServer() + ":" + strconv.Itoa(vc.serverPort) + "/nacos/v1/ns/instance" + path
+}
+
+// RegisterInstance registers an instance of service. Ephemeral instances
+// are removed by nacos unless beats are sent for them.
+func (vc *NacosClient) RegisterInstance(ctx context.Context, service Service, ip string, port int, weight float64, metadata map[string]string, ephemeral bool) error {
+	params := instanceParams(service, ip, port, ephemeral)
+	params["weight"] = strconv.FormatFloat(weight, 'f', -1, 64)
+	if len(metadata) > 0 {
+		b, _ := json.Marshal(metadata)
+		params["metadata"] = string(b)
+	}
+
+	if _, err := request(ctx, vc.Logger(), "POST", vc.instanceURL(""), params); err != nil {
+		return err
+	}
+	vc.Logger().Info("registered " + ip + ":" + strconv.Itoa(port) + " as " + service.Key())
+	return nil
+}
+
+// DeregisterInstance removes an instance of service.
+func (vc *NacosClient) DeregisterInstance(ctx context.Context, service Service, ip string, port int, ephemeral bool) error {
+	if _, err := request(ctx, vc.Logger(), "DELETE", vc.instanceURL(""), instanceParams(service, ip, port, ephemeral)); err != nil {
+		return err
+	}
+	vc.Logger().Info("deregistered " + ip + ":" + strconv.Itoa(port) + " from " + service.Key())
+	return nil
+}
+
+// Instances lists the instances of service, including unhealthy ones.
+func (vc *NacosClient) Instances(ctx context.Context, service Service) ([]Instance, error) {
+	params := instanceParams(service, "", 0, true)
+	delete(params, "ip")
+	delete(params, "port")
+	delete(params, "ephemeral")
+	params["healthyOnly"] = "false"
+
+	s, err := request(ctx, vc.Logger(), "GET", vc.instanceURL("/list"), params)
+	if err != nil {
+		return nil, err
+	}
+
+	var domain Domain
+	if err := json.Unmarshal([]byte(s), &domain); err != nil {
+		return nil, err
+	}
+	return domain.Instances, nil
+}
+
+// Register registers the instance.
+func (r *Registrar) Register(ctx context.Context) error {
+	return r.client.RegisterInstance(ctx, r.Service, r.IP, r.Port, 1, r.Metadata, true)
+}
+
+// Beat tells nacos the instance is alive, it registers the instance again
+// if nacos does not know it.
+func (r *Registrar) Beat(ctx context.Context) error {
+	beat, _ := json.Marshal(map[string]interface{}{
+		"serviceName": r.Service.GroupedName(),
+		"ip":          r.IP,
+		"port":        r.Port,
+		"metadata":    r.Metadata,
+		"scheduled":   true,
+	})
+	params := instanceParams(r.Service, r.IP, r.Port, true)
+	params["beat"] = string(beat)
+
+	s, err := request(ctx, r.client.Logger(), "PUT", r.client.instanceURL("/beat"), params)
+	if err != nil {
+		return err
+	}
+
+	var result struct {
+		Code int `json:"code"`
+	}
+	if json.Unmarshal([]byte(s), &result) == nil && result.Code == beatResourceNotFound {
+		return r.Register(ctx)
+	}
+	return nil
+}
+
+// Deregister removes the instance.
+func (r *Registrar) Deregister(ctx context.Context) error {
+	return r.client.DeregisterInstance(ctx, r.Service, r.IP, r.Port, true)
+}
@@ -0,0 +1,121 @@
+/*
+ * Copyright 1999-2018 Alibaba Group Holding Ltd.
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *      http://www.apache.org/licenses/LICENSE-2.0
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package nacos
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/nacos-group/nacos-coredns-plugin/nacos/nacostest"
+)
+
+func TestRegistrar(t *testing.T) {
+	var lock sync.Mutex
+	var calls []string
+	var metadata map[string]string
+	beats := 0
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		lock.Lock()
+		defer lock.Unlock()
+
+		q := req.URL.Query()
+		if q.Get("serviceName") != "INFRA@@dns-agent" || q.Get("namespaceId") != "dev" || q.Get("ip") != "10.0.0.1" || q.Get("port") != "53" {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		calls = append(calls, req.Method+" "+req.URL.Path)
+
+		switch req.Method {
+		case "POST":
+			json.Unmarshal([]byte(q.Get("metadata")), &metadata)
+			w.Write([]byte("ok"))
+		case "PUT":
+			beats++
+			// the instance expired after the second beat
+			if beats == 2 {
+				w.Write([]byte(`{"code":20404}`))
+				return
+			}
+			w.Write([]byte(`{"code":10200,"clientBeatInterval":5000}`))
+		case "DELETE":
+			w.Write([]byte("ok"))
+		}
+	}))
+	defer server.Close()
+
+	host := strings.TrimPrefix(server.URL, "http://")
+	port, _ := strconv.Atoi(strings.Split(host, ":")[1])
+	clock := nacostest.NewClock(time.Unix(1500000000, 0))
+	client := &NacosClient{serverPort: port, clock: clock}
+	client.SetServers([]string{strings.Split(host, ":")[0]})
+
+	r := NewRegistrar(client, Service{Name: "dns-agent", Group: "INFRA", Namespace: "dev"}, "10.0.0.1", 53)
+	r.Metadata["version"] = Version
+	r.Start()
+	for i := 0; i < 2; i++ {
+		clock.BlockUntil(1)
+		clock.Advance(r.Interval)
+	}
+	clock.BlockUntil(1)
+	if err := r.Stop(); err != nil {
+		t.Fatal(err)
+	}
+
+	lock.Lock()
+	defer lock.Unlock()
+	expected := []string{"POST /nacos/v1/ns/instance", "PUT /nacos/v1/ns/instance/beat", "PUT /nacos/v1/ns/instance/beat",
+		"POST /nacos/v1/ns/instance", "DELETE /nacos/v1/ns/instance"}
+	if strings.Join(calls, ",") != strings.Join(expected, ",") {
+		t.Fatalf("expected %v, got %v", expected, calls)
+	}
+	if metadata["version"] != Version {
+		t.Fatalf("unexpected metadata %v", metadata)
+	}
+}
+
+func TestRegistrar_StopForReload(t *testing.T) {
+	var lock sync.Mutex
+	var methods []string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		lock.Lock()
+		defer lock.Unlock()
+		methods = append(methods, req.Method)
+		w.Write([]byte("ok"))
+	}))
+	defer server.Close()
+
+	host := strings.TrimPrefix(server.URL, "http://")
+	port, _ := strconv.Atoi(strings.Split(host, ":")[1])
+	client := &NacosClient{serverPort: port, clock: nacostest.NewClock(time.Unix(1500000000, 0))}
+	client.SetServers([]string{strings.Split(host, ":")[0]})
+
+	vs := &Nacos{NacosClientImpl: client, Registrar: NewRegistrar(client, Service{Name: "dns-agent"}, "10.0.0.1", 53)}
+	vs.Registrar.Start()
+	vs.OnRestart()
+	if err := vs.OnShutdown(); err != nil {
+		t.Fatal(err)
+	}
+
+	lock.Lock()
+	defer lock.Unlock()
+	if strings.Join(methods, ",") != "POST" {
+		t.Fatalf("expected the instance to stay registered for the reloaded Corefile, got %v", methods)
+	}
+}
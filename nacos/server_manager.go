@@ -18,17 +18,42 @@ import (
 	"math/rand"
 	"reflect"
 	"os"
+
+	"github.com/cihub/seelog"
 )
 
 type ServerManager struct {
 	serverList      []string
 	lastRefreshTime int64
 	cursor          int
+	// defaults to SystemClock
+	clock Clock
+	// defaults to NacosClientLogger
+	logger seelog.LoggerInterface
+}
+
+// SetClock sets the clock the server list is refreshed by.
+func (manager *ServerManager) SetClock(clock Clock) {
+	manager.clock = clock
+}
+
+func (manager *ServerManager) log() seelog.LoggerInterface {
+	if manager.logger == nil {
+		return NacosClientLogger
+	}
+	return manager.logger
+}
+
+func (manager *ServerManager) now() int64 {
+	if manager.clock == nil {
+		return CurrentMillis()
+	}
+	return millis(manager.clock.Now())
 }
 
 // get nacos ip list from address by env
 func (manager *ServerManager) RefreshServerListIfNeed() []string {
-	if CurrentMillis()-manager.lastRefreshTime < 60*1000 && len(manager.serverList) > 0 {
+	if manager.now()-manager.lastRefreshTime < 60*1000 && len(manager.serverList) > 0 {
 		return manager.serverList
 	}
 
@@ -46,11 +71,11 @@ func (manager *ServerManager) RefreshServerListIfNeed() []string {
 
 	if len(servers) > 0 {
 		if !reflect.DeepEqual(manager.serverList, servers) {
-			NacosClientLogger.Info("server list is updated, old: ", manager.serverList, ", new: ", servers)
+			manager.log().Info("server list is updated, old: ", manager.serverList, ", new: ", servers)
 		}
 		manager.serverList = servers
 
-		manager.lastRefreshTime = CurrentMillis()
+		manager.lastRefreshTime = manager.now()
 	}
 
 	return manager.serverList
@@ -17,6 +17,9 @@ import (
 	"testing"
 	"os"
 	"strings"
+	"time"
+
+	"github.com/nacos-group/nacos-coredns-plugin/nacos/nacostest"
 )
 
 func TestServerManager_NextServer(t *testing.T) {
@@ -40,3 +43,23 @@ func TestServerManager_RefreshServerListIfNeed(t *testing.T) {
 	}
 
 }
+
+func TestServerManager_RefreshServerListExpiry(t *testing.T) {
+	defer os.Unsetenv("nacos_server_list")
+	os.Setenv("nacos_server_list", "2.2.2.2")
+	clock := nacostest.NewClock(time.Unix(1500000000, 0))
+	sm := ServerManager{}
+	sm.SetClock(clock)
+	sm.RefreshServerListIfNeed()
+
+	os.Setenv("nacos_server_list", "3.3.3.3")
+	clock.Advance(59 * time.Second)
+	if servers := sm.RefreshServerListIfNeed(); len(servers) != 1 || servers[0] != "2.2.2.2" {
+		t.Fatalf("expected the list to be kept for a minute, got %v", servers)
+	}
+
+	clock.Advance(time.Second)
+	if servers := sm.RefreshServerListIfNeed(); len(servers) != 1 || servers[0] != "3.3.3.3" {
+		t.Fatalf("expected the list to be refreshed after a minute, got %v", servers)
+	}
+}
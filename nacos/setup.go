@@ -14,14 +14,24 @@
 package nacos
 
 import (
-	"github.com/mholt/caddy"
-	"github.com/coredns/coredns/plugin"
-	"github.com/coredns/coredns/core/dnsserver"
+	"crypto/tls"
 	"fmt"
-	"strings"
+	"io/ioutil"
+	"math"
+	"net"
+	"os"
+	"regexp"
 	"strconv"
-	"github.com/coredns/coredns/plugin/pkg/parse"
-	"github.com/coredns/coredns/plugin/proxy"
+	"strings"
+	"time"
+
+	"github.com/cihub/seelog"
+	"github.com/coredns/coredns/core/dnsserver"
+	"github.com/coredns/coredns/plugin"
+	"github.com/coredns/coredns/plugin/metrics"
+	"github.com/coredns/coredns/plugin/pkg/fall"
+	"github.com/mholt/caddy"
+	"github.com/miekg/dns"
 )
 
 func init() {
@@ -29,89 +39,619 @@ func init() {
 		ServerType: "dns",
 		Action:     setup,
 	})
-	fmt.Println("register nacos plugin")
+	// stdout belongs to the commands importing the package
+	fmt.Fprintln(os.Stderr, "register nacos plugin")
 }
 
 func setup(c *caddy.Controller) error {
 	fmt.Println("setup nacos plugin")
+	vs, err := NacosParse(c)
+	if err != nil {
+		return plugin.Error("nacos", err)
+	}
+
+	c.OnStartup(func() error {
+		m := dnsserver.GetConfig(c).Handler("prometheus")
+		if m == nil {
+			return nil
+		}
+		if x, ok := m.(*metrics.Metrics); ok {
+			return registerMetrics(x.Reg)
+		}
+		return nil
+	})
+	c.OnStartup(vs.OnStartup)
+	c.OnRestart(vs.OnRestart)
+	c.OnShutdown(vs.OnShutdown)
+
 	dnsserver.GetConfig(c).AddPlugin(func(next plugin.Handler) plugin.Handler {
-		vs, _ := NacosParse(c)
 		vs.Next = next
-		Inited = true
 		return vs
 	})
 	return nil
 }
 
-func NacosParse(c *caddy.Controller) (*Nacos, error) {
-	fmt.Println("init nacos plugin...")
-	nacosImpl := Nacos{}
-	var servers = make([]string, 0)
-	serverPort := 8848
-	for c.Next() {
-		nacosImpl.Zones = c.RemainingArgs()
-
-		if c.NextBlock() {
-			for {
-				switch v :=c.Val();v {
-				case "nacos_server":
-					servers = strings.Split(c.RemainingArgs()[0], ",")
-					/* it is a noop now */
-				case "nacos_server_port":
-					port, err := strconv.Atoi(c.RemainingArgs()[0])
-					if err != nil {
-						serverPort = port
-					}
-				case "cache_ttl":
-					ttl, err := strconv.Atoi(c.RemainingArgs()[0])
-					if err != nil {
-						DNSTTL = uint32(ttl)
-					}
-				case "upstream":
-					args := c.RemainingArgs()
-					if len(args) == 0 {
-						return &Nacos{}, c.ArgErr()
-					}
-					ups, err := parse.HostPortOrFile(args...)
-					if err != nil {
-						return &Nacos{}, err
-					}
+// Config is the nacos block of a Corefile.
+type Config struct {
+	Zones           []string
+	Servers         []string
+	ServerPort      int
+	CacheTTL        uint32
+	CacheDir        string
+	LogPath         string
+	LogLevel        string
+	LogOutput       string
+	LogFormat       string
+	LogSample       float64
+	Upstreams       []string
+	ZoneUpstreams   map[string][]string
+	UpstreamTLS     map[string]*tls.Config
+	Policy          string
+	HealthCheck     time.Duration
+	MaxFails        uint32
+	Fall            fall.F
+	FailoverDir     string
+	Prefetch        bool
+	PrefetchDoms    []string
+	PrefetchTimeout time.Duration
+	NameRules       []NameRule
+	ServiceRules    []ServiceRule
+	Filter          ServiceFilter
+	HealthWindow    time.Duration
+	AdminAddr       string
+	AdminToken      string
+	Register        bool
+	RegisterService Service
+	TsigKeys        map[string]TsigKey
+	RegistryFile    string
+}
+
+// directives that may be given more than once, they are checked for duplicate keys instead.
+var repeatableDirectives = map[string]bool{
+	"upstream_zone":   true,
+	"upstream_tls":    true,
+	"name_to_service": true,
+	"service_to_name": true,
+	"include":         true,
+	"exclude":         true,
+	"tsig_key":        true,
+}
+
+// directives that need a nacos server, they cannot be used with registry_file.
+var nacosDirectives = []string{"nacos_server", "nacos_server_port", "cache_dir", "failover_dir",
+	"prefetch", "health_window", "admin", "register", "tsig_key"}
+
+// ParseConfig parses and validates the nacos block without side effects.
+func ParseConfig(c *caddy.Controller) (*Config, error) {
+	cfg := &Config{
+		ServerPort:      8848,
+		CacheTTL:        DefaultDNSTTL,
+		ZoneUpstreams:   make(map[string][]string),
+		UpstreamTLS:     make(map[string]*tls.Config),
+		Policy:          PolicyRandom,
+		HealthCheck:     DefaultHealthCheckInterval,
+		MaxFails:        DefaultMaxFails,
+		PrefetchTimeout: DefaultPrefetchTimeout,
+		HealthWindow:    DefaultHealthWindow,
+		LogSample:       1,
+		TsigKeys:        make(map[string]TsigKey),
+	}
+
+	if !c.Next() {
+		return cfg, nil
+	}
+
+	zones := c.RemainingArgs()
+	if len(zones) == 0 {
+		zones = c.ServerBlockKeys
+	}
+	for _, zone := range zones {
+		zone = plugin.Host(zone).Normalize()
+		if _, ok := dns.IsDomainName(zone); !ok {
+			return nil, c.Errf("invalid zone '%s'", zone)
+		}
+		cfg.Zones = append(cfg.Zones, zone)
+	}
 
-					var ups1 []string;
+	// line of each directive, to report conflicts between directives
+	lines := make(map[string]int)
 
-					for _, host := range ups {
-						if strings.Contains(host, "127.0.0.1"){
-							continue
-						} else {
-							ups1 = append(ups1, host)
-							break
-						}
+	for c.NextBlock() {
+		directive := c.Val()
+		if _, ok := lines[directive]; ok && !repeatableDirectives[directive] {
+			return nil, c.Errf("duplicate directive '%s', first given on line %d", directive, lines[directive])
+		}
+		lines[directive] = c.Line()
+
+		switch directive {
+		case "nacos_server":
+			args := c.RemainingArgs()
+			if len(args) == 0 {
+				return nil, c.ArgErr()
+			}
+			for _, arg := range args {
+				for _, server := range strings.Split(arg, ",") {
+					server = strings.TrimSpace(server)
+					if server == "" {
+						continue
 					}
-					fmt.Println("upstreams: ", ups1)
-					nacosImpl.Proxy = proxy.NewLookup(ups1)
-				case "cache_dir":
-					CachePath = c.RemainingArgs()[0]
-				case "log_path":
-					LogPath = c.RemainingArgs()[0]
-				default:
-					if c.Val() != "}" {
-						return &Nacos{}, c.Errf("unknown property '%s'", c.Val())
+					if _, ok := dns.IsDomainName(server); net.ParseIP(server) == nil && !ok {
+						return nil, c.Errf("invalid nacos_server '%s'", server)
 					}
+					cfg.Servers = append(cfg.Servers, server)
 				}
-
-				if !c.Next() {
-					break
+			}
+			if len(cfg.Servers) == 0 {
+				return nil, c.ArgErr()
+			}
+		case "nacos_server_port":
+			port, err := intArg(c, 1, 65535)
+			if err != nil {
+				return nil, err
+			}
+			cfg.ServerPort = port
+		case "cache_ttl":
+			ttl, err := intArg(c, 0, math.MaxInt32)
+			if err != nil {
+				return nil, err
+			}
+			cfg.CacheTTL = uint32(ttl)
+		case "upstream":
+			args := c.RemainingArgs()
+			if len(args) == 0 {
+				return nil, c.ArgErr()
+			}
+			ups, err := ParseUpstreams(args...)
+			if err != nil {
+				return nil, c.Errf("invalid upstream: %v", err)
+			}
+			for _, host := range ups {
+				// skip ourselves, resolv.conf usually points to the sidecar
+				if strings.Contains(host, "127.0.0.1") {
+					continue
 				}
+				cfg.Upstreams = append(cfg.Upstreams, host)
+			}
+			if len(cfg.Upstreams) == 0 {
+				return nil, c.Errf("no upstream left in '%s' after skipping 127.0.0.1", strings.Join(args, " "))
+			}
+		case "upstream_zone":
+			args := c.RemainingArgs()
+			if len(args) < 2 {
+				return nil, c.ArgErr()
+			}
+			zone := plugin.Host(args[0]).Normalize()
+			if _, ok := dns.IsDomainName(zone); !ok {
+				return nil, c.Errf("invalid upstream_zone '%s'", args[0])
+			}
+			if _, ok := cfg.ZoneUpstreams[zone]; ok {
+				return nil, c.Errf("duplicate upstream_zone '%s'", zone)
+			}
+			ups, err := ParseUpstreams(args[1:]...)
+			if err != nil {
+				return nil, c.Errf("invalid upstream_zone '%s': %v", zone, err)
+			}
+			cfg.ZoneUpstreams[zone] = ups
+		case "upstream_tls":
+			args := c.RemainingArgs()
+			if len(args) < 2 || len(args) > 3 {
+				return nil, c.ArgErr()
+			}
+			ups, err := ParseUpstreams(args[0])
+			if err != nil || len(ups) != 1 || !strings.Contains(ups[0], "://") {
+				return nil, c.Errf("upstream_tls expects a tls:// or https:// upstream, got '%s'", args[0])
+			}
+			if _, ok := cfg.UpstreamTLS[ups[0]]; ok {
+				return nil, c.Errf("duplicate upstream_tls for '%s'", ups[0])
+			}
+			caFile := ""
+			if len(args) == 3 {
+				caFile = args[2]
+			}
+			tlsConfig, err := NewTLSConfig(args[1], caFile)
+			if err != nil {
+				return nil, c.Errf("invalid upstream_tls for '%s': %v", args[0], err)
+			}
+			cfg.UpstreamTLS[ups[0]] = tlsConfig
+		case "upstream_policy":
+			policy, err := singleArg(c)
+			if err != nil {
+				return nil, err
+			}
+			switch policy {
+			case PolicyRandom, PolicyRoundRobin, PolicySequential:
+				cfg.Policy = policy
+			default:
+				return nil, c.Errf("unknown upstream_policy '%s'", policy)
+			}
+		case "upstream_health_check":
+			interval, err := durationArg(c, 0)
+			if err != nil {
+				return nil, err
+			}
+			cfg.HealthCheck = interval
+		case "upstream_max_fails":
+			n, err := intArg(c, 0, math.MaxInt32)
+			if err != nil {
+				return nil, err
+			}
+			cfg.MaxFails = uint32(n)
+		case "fallthrough":
+			args := c.RemainingArgs()
+			for _, zone := range args {
+				if _, ok := dns.IsDomainName(zone); !ok {
+					return nil, c.Errf("invalid fallthrough zone '%s'", zone)
+				}
+			}
+			cfg.Fall.SetZonesFromArgs(args)
+		case "cache_dir":
+			dir, err := singleArg(c)
+			if err != nil {
+				return nil, err
+			}
+			cfg.CacheDir = dir
+		case "log_path":
+			path, err := singleArg(c)
+			if err != nil {
+				return nil, err
+			}
+			cfg.LogPath = path
+		case "log_level":
+			level, err := singleArg(c)
+			if err != nil {
+				return nil, err
+			}
+			if _, ok := seelog.LogLevelFromString(level); !ok {
+				return nil, c.Errf("unknown log_level '%s'", level)
+			}
+			cfg.LogLevel = level
+		case "log_output":
+			output, err := singleArg(c)
+			if err != nil {
+				return nil, err
+			}
+			switch output {
+			case LogOutputFile, LogOutputStderr, LogOutputClog:
+				cfg.LogOutput = output
+			default:
+				return nil, c.Errf("unknown log_output '%s'", output)
+			}
+		case "log_format":
+			format, err := singleArg(c)
+			if err != nil {
+				return nil, err
+			}
+			if _, ok := logFormats[format]; !ok {
+				return nil, c.Errf("unknown log_format '%s'", format)
+			}
+			cfg.LogFormat = format
+		case "log_sample":
+			arg, err := singleArg(c)
+			if err != nil {
+				return nil, err
+			}
+			rate, err := strconv.ParseFloat(arg, 64)
+			if err != nil || rate < 0 || rate > 1 {
+				return nil, c.Errf("invalid log_sample '%s', expected a rate between 0 and 1", arg)
+			}
+			cfg.LogSample = rate
+		case "failover_dir":
+			dir, err := singleArg(c)
+			if err != nil {
+				return nil, err
+			}
+			if info, err := os.Stat(dir); err == nil && !info.IsDir() {
+				return nil, c.Errf("failover_dir '%s' is not a directory", dir)
+			}
+			cfg.FailoverDir = dir
+		case "prefetch":
+			cfg.Prefetch = true
+			args := c.RemainingArgs()
+			// prefetch file <path> reads the doms from a file, anything else lists them
+			if len(args) > 0 && args[0] == "file" {
+				if len(args) != 2 {
+					return nil, c.ArgErr()
+				}
+				doms, err := ReadPrefetchFile(args[1])
+				if err != nil {
+					return nil, c.Errf("prefetch file %s: %v", args[1], err)
+				}
+				cfg.PrefetchDoms = doms
+			} else {
+				cfg.PrefetchDoms = args
+			}
+		case "prefetch_timeout":
+			timeout, err := durationArg(c, time.Millisecond)
+			if err != nil {
+				return nil, err
+			}
+			cfg.PrefetchTimeout = timeout
+		case "health_window":
+			window, err := durationArg(c, time.Second)
+			if err != nil {
+				return nil, err
+			}
+			cfg.HealthWindow = window
+		case "admin":
+			args := c.RemainingArgs()
+			if len(args) == 0 || len(args) > 2 {
+				return nil, c.ArgErr()
+			}
+			if _, _, err := net.SplitHostPort(args[0]); err != nil {
+				return nil, c.Errf("invalid admin address '%s': %v", args[0], err)
+			}
+			cfg.AdminAddr = args[0]
+			if len(args) == 2 {
+				cfg.AdminToken = args[1]
+			}
+		case "register":
+			args := c.RemainingArgs()
+			if len(args) > 3 {
+				return nil, c.ArgErr()
+			}
+			args = append(args, "", "", "")
+			cfg.Register = true
+			cfg.RegisterService = Service{Name: args[0], Group: args[1], Namespace: args[2]}
+			if cfg.RegisterService.Name == "" {
+				cfg.RegisterService.Name = DefaultRegisterService
+			}
+		case "name_to_service":
+			args := c.RemainingArgs()
+			if len(args) < 2 || len(args) > 4 {
+				return nil, c.ArgErr()
 			}
+			// DNS names are case insensitive
+			pattern, err := regexp.Compile("(?i)" + args[0])
+			if err != nil {
+				return nil, c.Errf("invalid name_to_service pattern '%s': %v", args[0], err)
+			}
+			rule := NameRule{Pattern: pattern, Service: args[1]}
+			if len(args) > 2 {
+				rule.Group = args[2]
+			}
+			if len(args) > 3 {
+				rule.Namespace = args[3]
+			}
+			cfg.NameRules = append(cfg.NameRules, rule)
+		case "service_to_name":
+			args := c.RemainingArgs()
+			if len(args) != 2 {
+				return nil, c.ArgErr()
+			}
+			pattern, err := regexp.Compile(args[0])
+			if err != nil {
+				return nil, c.Errf("invalid service_to_name pattern '%s': %v", args[0], err)
+			}
+			if _, ok := dns.IsDomainName(args[1]); !ok {
+				return nil, c.Errf("invalid service_to_name name '%s'", args[1])
+			}
+			cfg.ServiceRules = append(cfg.ServiceRules, ServiceRule{Pattern: pattern, Name: args[1]})
+		case "include", "exclude":
+			args := c.RemainingArgs()
+			if len(args) == 0 || len(args) > 3 {
+				return nil, c.ArgErr()
+			}
+			args = append(args, "", "")
+			m, err := NewServiceMatcher(args[0], args[1], args[2])
+			if err != nil {
+				return nil, c.Errf("invalid %s pattern: %v", directive, err)
+			}
+			if directive == "include" {
+				cfg.Filter.Include = append(cfg.Filter.Include, m)
+			} else {
+				cfg.Filter.Exclude = append(cfg.Filter.Exclude, m)
+			}
+		case "tsig_key":
+			args := c.RemainingArgs()
+			if len(args) != 3 {
+				return nil, c.ArgErr()
+			}
+			name := dns.Fqdn(strings.ToLower(args[0]))
+			if _, ok := dns.IsDomainName(name); !ok {
+				return nil, c.Errf("invalid tsig_key name '%s'", args[0])
+			}
+			if _, ok := cfg.TsigKeys[name]; ok {
+				return nil, c.Errf("duplicate tsig_key '%s'", name)
+			}
+			key, err := NewTsigKey(args[1], args[2])
+			if err != nil {
+				return nil, c.Errf("invalid tsig_key '%s': %v", name, err)
+			}
+			cfg.TsigKeys[name] = key
+		case "registry_file":
+			path, err := singleArg(c)
+			if err != nil {
+				return nil, err
+			}
+			b, err := ioutil.ReadFile(path)
+			if err != nil {
+				return nil, c.Errf("failed to read registry_file '%s': %v", path, err)
+			}
+			if _, err := ParseStaticServices(b); err != nil {
+				return nil, c.Errf("invalid registry_file '%s': %v", path, err)
+			}
+			cfg.RegistryFile = path
+		default:
+			return nil, c.Errf("unknown property '%s'", directive)
+		}
+	}
+
+	if c.Next() {
+		return nil, c.Errf("nacos can only be used once per server block")
+	}
+
+	hasUpstream := len(cfg.Upstreams) > 0 || len(cfg.ZoneUpstreams) > 0
+	for _, directive := range []string{"upstream_policy", "upstream_health_check", "upstream_max_fails", "upstream_tls"} {
+		if line, ok := lines[directive]; ok && !hasUpstream {
+			return nil, errAt(c, line, "%s requires upstream or upstream_zone", directive)
+		}
+	}
+
+	for addr := range cfg.UpstreamTLS {
+		if !containsUpstream(cfg, addr) {
+			return nil, errAt(c, lines["upstream_tls"], "upstream_tls for '%s' which is not an upstream", addr)
+		}
+	}
+
+	if line, ok := lines["fallthrough"]; ok && len(cfg.Fall.Zones) == 1 && cfg.Fall.Zones[0] == "." && hasUpstream {
+		return nil, errAt(c, line, "fallthrough for all zones conflicts with upstream, the upstream would never be used")
+	}
+
+	if line, ok := lines["log_path"]; ok && cfg.LogOutput != "" && cfg.LogOutput != LogOutputFile {
+		return nil, errAt(c, line, "log_path requires log_output %s", LogOutputFile)
+	}
+
+	if line, ok := lines["log_format"]; ok && cfg.LogFormat == LogFormatJSON && cfg.LogOutput == LogOutputClog {
+		return nil, errAt(c, line, "log_format %s conflicts with log_output %s, clog formats the log itself", LogFormatJSON, LogOutputClog)
+	}
+
+	if line, ok := lines["prefetch_timeout"]; ok && !cfg.Prefetch {
+		return nil, errAt(c, line, "prefetch_timeout requires prefetch")
+	}
+
+	if _, ok := lines["registry_file"]; ok {
+		for _, directive := range nacosDirectives {
+			if line, ok := lines[directive]; ok {
+				return nil, errAt(c, line, "%s conflicts with registry_file, the services are not in nacos", directive)
+			}
+		}
+	}
+
+	return cfg, nil
+}
+
+func NacosParse(c *caddy.Controller) (*Nacos, error) {
+	fmt.Println("init nacos plugin...")
+	cfg, err := ParseConfig(c)
+	if err != nil {
+		return nil, err
+	}
 
+	nacosImpl := Nacos{Zones: cfg.Zones, Fall: cfg.Fall, TTL: cfg.CacheTTL, config: cfg,
+		Mapper: NameMapper{NameRules: cfg.NameRules, ServiceRules: cfg.ServiceRules}, Filter: cfg.Filter,
+		HealthWindow: cfg.HealthWindow, LogSample: cfg.LogSample, TsigKeys: cfg.TsigKeys}
+
+	clientConfig := ClientConfig{Servers: cfg.Servers, ServerPort: cfg.ServerPort, CachePath: cfg.CacheDir}
+	if cfg.LogPath != "" || cfg.LogLevel != "" || cfg.LogOutput != "" || cfg.LogFormat != "" {
+		logger, err := NewLogger(LoggerConfig{Level: cfg.LogLevel, Output: cfg.LogOutput, Format: cfg.LogFormat, Dir: cfg.LogPath})
+		if err != nil {
+			return nil, err
 		}
+		clientConfig.Logger = logger
+		nacosImpl.Logger = logger
+	}
 
+	newForwarder := func(addrs []string) *Forwarder {
+		f := NewForwarder(addrs)
+		f.Policy = cfg.Policy
+		f.HealthCheck = cfg.HealthCheck
+		f.MaxFails = cfg.MaxFails
+		f.Logger = clientConfig.Logger
+		for _, u := range f.Upstreams() {
+			if tlsConfig, ok := cfg.UpstreamTLS[u.Addr()]; ok {
+				u.SetTLSConfig(tlsConfig)
+			}
+		}
+		return f
+	}
 
-		client := NewNacosClient(servers, serverPort)
-		nacosImpl.NacosClientImpl = client
-		nacosImpl.DNSCache = NewConcurrentMap()
+	if len(cfg.Upstreams) > 0 {
+		fmt.Println("upstreams: ", cfg.Upstreams)
+		nacosImpl.Upstream = newForwarder(cfg.Upstreams)
+	}
+
+	nacosImpl.ZoneUpstreams = make(map[string]*Forwarder)
+	for zone, addrs := range cfg.ZoneUpstreams {
+		fmt.Println("upstreams of "+zone+": ", addrs)
+		nacosImpl.ZoneUpstreams[zone] = newForwarder(addrs)
+	}
 
+	nacosImpl.DNSCache = NewConcurrentMap()
+
+	if cfg.RegistryFile != "" {
+		discovery, err := NewStaticDiscovery(cfg.RegistryFile, clientConfig.Logger)
+		if err != nil {
+			return nil, err
+		}
+		nacosImpl.Discovery = discovery
 		return &nacosImpl, nil
 	}
-	return &Nacos{}, nil
+
+	client := NewNacosClientWithConfig(clientConfig)
+	nacosImpl.NacosClientImpl = client
+	if cfg.FailoverDir != "" {
+		client.SetFailoverDir(cfg.FailoverDir)
+	}
+
+	if cfg.Register {
+		port, err := strconv.Atoi(dnsserver.GetConfig(c).Port)
+		if err != nil {
+			port = 53
+		}
+		registrar := NewRegistrar(client, cfg.RegisterService, LocalIP(), port)
+		registrar.Metadata["version"] = Version
+		registrar.Metadata["zones"] = strings.Join(cfg.Zones, ",")
+		nacosImpl.Registrar = registrar
+	}
+
+	if cfg.AdminAddr != "" {
+		nacosImpl.Admin = NewAdmin(cfg.AdminAddr, cfg.AdminToken, &nacosImpl)
+	}
+
+	return &nacosImpl, nil
+}
+
+func containsUpstream(cfg *Config, addr string) bool {
+	for _, u := range cfg.Upstreams {
+		if u == addr {
+			return true
+		}
+	}
+	for _, ups := range cfg.ZoneUpstreams {
+		for _, u := range ups {
+			if u == addr {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+func singleArg(c *caddy.Controller) (string, error) {
+	args := c.RemainingArgs()
+	if len(args) != 1 {
+		return "", c.ArgErr()
+	}
+	return args[0], nil
+}
+
+func intArg(c *caddy.Controller, min, max int) (int, error) {
+	directive := c.Val()
+	arg, err := singleArg(c)
+	if err != nil {
+		return 0, err
+	}
+	n, err := strconv.Atoi(arg)
+	if err != nil || n < min || n > max {
+		return 0, c.Errf("invalid %s '%s', expected an integer between %d and %d", directive, arg, min, max)
+	}
+	return n, nil
+}
+
+func durationArg(c *caddy.Controller, min time.Duration) (time.Duration, error) {
+	directive := c.Val()
+	arg, err := singleArg(c)
+	if err != nil {
+		return 0, err
+	}
+	d, err := time.ParseDuration(arg)
+	if err != nil || d < min {
+		return 0, c.Errf("invalid %s '%s', expected a duration of at least %s", directive, arg, min)
+	}
+	return d, nil
+}
+
+// errAt is c.Errf for a line other than the current one.
+func errAt(c *caddy.Controller, line int, format string, args ...interface{}) error {
+	return fmt.Errorf("%s:%d - Error during parsing: %s", c.File(), line, fmt.Sprintf(format, args...))
 }
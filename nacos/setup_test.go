@@ -16,9 +16,11 @@ package nacos
 import (
 	"testing"
 	"github.com/mholt/caddy"
+	"github.com/miekg/dns"
 	"strings"
 	"fmt"
 	os "os"
+	"time"
 )
 
 func TestNacosParse(t *testing.T) {
@@ -62,3 +64,189 @@ func TestNacosParse(t *testing.T) {
 		}
 	}
 }
+
+func TestParseConfig(t *testing.T) {
+	tests := []struct {
+		input              string
+		expectedErrContent string // substring from the expected error. Empty for positive cases.
+		check              func(cfg *Config) bool
+	}{
+		// nacos_server
+		{"nacos {\n nacos_server 192.168.0.1,192.168.0.2 nacos.local\n}", "",
+			func(cfg *Config) bool { return len(cfg.Servers) == 3 && cfg.Servers[2] == "nacos.local" }},
+		{"nacos {\n nacos_server\n}", "Testfile:2 - Error during parsing: Wrong argument count", nil},
+		{"nacos {\n nacos_server ,\n}", "Wrong argument count", nil},
+		{"nacos {\n nacos_server 192.168.0.1\n nacos_server 192.168.0.2\n}", "Testfile:3 - Error during parsing: duplicate directive 'nacos_server', first given on line 2", nil},
+		// nacos_server_port
+		{"nacos {\n nacos_server_port 8849\n}", "", func(cfg *Config) bool { return cfg.ServerPort == 8849 }},
+		{"nacos {\n nacos_server_port abc\n}", "Testfile:2 - Error during parsing: invalid nacos_server_port 'abc'", nil},
+		{"nacos {\n nacos_server_port 0\n}", "invalid nacos_server_port '0'", nil},
+		{"nacos {\n nacos_server_port 65536\n}", "invalid nacos_server_port '65536'", nil},
+		{"nacos {\n nacos_server_port\n}", "Wrong argument count", nil},
+		// cache_ttl
+		{"nacos {\n cache_ttl 30\n}", "", func(cfg *Config) bool { return cfg.CacheTTL == 30 }},
+		{"nacos {\n cache_ttl -1\n}", "invalid cache_ttl '-1'", nil},
+		{"nacos {\n cache_ttl 1 2\n}", "Wrong argument count", nil},
+		// upstream
+		{"nacos {\n upstream 8.8.8.8 127.0.0.1 tls://1.1.1.1\n}", "",
+			func(cfg *Config) bool { return len(cfg.Upstreams) == 2 && cfg.Upstreams[1] == "tls://1.1.1.1:853" }},
+		{"nacos {\n upstream\n}", "Wrong argument count", nil},
+		{"nacos {\n upstream 127.0.0.1\n}", "no upstream left", nil},
+		{"nacos {\n upstream /no/such/resolv.conf\n}", "invalid upstream", nil},
+		// upstream_zone
+		{"nacos {\n upstream_zone corp.example 10.0.0.1\n upstream_zone dev.example 10.0.1.1\n}", "",
+			func(cfg *Config) bool { return len(cfg.ZoneUpstreams) == 2 && cfg.ZoneUpstreams["corp.example."][0] == "10.0.0.1:53" }},
+		{"nacos {\n upstream_zone corp.example\n}", "Wrong argument count", nil},
+		{"nacos {\n upstream_zone corp.example 10.0.0.1\n upstream_zone CORP.example. 10.0.0.2\n}", "Testfile:3 - Error during parsing: duplicate upstream_zone 'corp.example.'", nil},
+		// upstream_tls
+		{"nacos {\n upstream tls://1.1.1.1\n upstream_tls tls://1.1.1.1 cloudflare-dns.com\n}", "",
+			func(cfg *Config) bool { return cfg.UpstreamTLS["tls://1.1.1.1:853"].ServerName == "cloudflare-dns.com" }},
+		{"nacos {\n upstream 8.8.8.8\n upstream_tls 8.8.8.8 dns.google\n}", "upstream_tls expects a tls:// or https:// upstream", nil},
+		{"nacos {\n upstream tls://1.1.1.1\n upstream_tls tls://1.1.1.1 cloudflare-dns.com /no/such/ca.pem\n}", "invalid upstream_tls", nil},
+		{"nacos {\n upstream tls://1.1.1.1\n upstream_tls tls://9.9.9.9 dns.quad9.net\n}", "Testfile:3 - Error during parsing: upstream_tls for 'tls://9.9.9.9:853' which is not an upstream", nil},
+		{"nacos {\n upstream_tls tls://9.9.9.9 dns.quad9.net\n}", "upstream_tls requires upstream or upstream_zone", nil},
+		// upstream_policy
+		{"nacos {\n upstream 8.8.8.8\n upstream_policy round_robin\n}", "", func(cfg *Config) bool { return cfg.Policy == PolicyRoundRobin }},
+		{"nacos {\n upstream 8.8.8.8\n upstream_policy fastest\n}", "unknown upstream_policy 'fastest'", nil},
+		{"nacos {\n upstream_policy random\n}", "Testfile:2 - Error during parsing: upstream_policy requires upstream or upstream_zone", nil},
+		// upstream_health_check
+		{"nacos {\n upstream 8.8.8.8\n upstream_health_check 1s\n}", "", func(cfg *Config) bool { return cfg.HealthCheck == time.Second }},
+		{"nacos {\n upstream 8.8.8.8\n upstream_health_check soon\n}", "invalid upstream_health_check 'soon'", nil},
+		{"nacos {\n upstream 8.8.8.8\n upstream_health_check -1s\n}", "invalid upstream_health_check '-1s'", nil},
+		// upstream_max_fails
+		{"nacos {\n upstream 8.8.8.8\n upstream_max_fails 0\n}", "", func(cfg *Config) bool { return cfg.MaxFails == 0 }},
+		{"nacos {\n upstream 8.8.8.8\n upstream_max_fails x\n}", "invalid upstream_max_fails 'x'", nil},
+		// fallthrough
+		{"nacos {\n fallthrough\n}", "", func(cfg *Config) bool { return len(cfg.Fall.Zones) == 1 && cfg.Fall.Zones[0] == "." }},
+		{"nacos {\n upstream 8.8.8.8\n fallthrough example.org\n}", "", func(cfg *Config) bool { return cfg.Fall.Zones[0] == "example.org." }},
+		{"nacos {\n upstream 8.8.8.8\n fallthrough\n}", "Testfile:3 - Error during parsing: fallthrough for all zones conflicts with upstream", nil},
+		// cache_dir, log_path, failover_dir
+		{"nacos {\n cache_dir /tmp/nacos-cache\n log_path /tmp/nacos-logs\n failover_dir /tmp\n}", "",
+			func(cfg *Config) bool {
+				return cfg.CacheDir == "/tmp/nacos-cache" && cfg.LogPath == "/tmp/nacos-logs" && cfg.FailoverDir == "/tmp"
+			}},
+		{"nacos {\n cache_dir\n}", "Wrong argument count", nil},
+		{"nacos {\n log_path a b\n}", "Wrong argument count", nil},
+		{"nacos {\n failover_dir /etc/hosts\n}", "failover_dir '/etc/hosts' is not a directory", nil},
+		// prefetch, prefetch_timeout
+		{"nacos {\n prefetch hello1 hello2\n prefetch_timeout 3s\n}", "",
+			func(cfg *Config) bool {
+				return cfg.Prefetch && len(cfg.PrefetchDoms) == 2 && cfg.PrefetchTimeout == 3*time.Second
+			}},
+		{"nacos {\n prefetch\n}", "", func(cfg *Config) bool { return cfg.Prefetch && len(cfg.PrefetchDoms) == 0 }},
+		{"nacos {\n prefetch file testdata/prefetch.txt\n}", "", func(cfg *Config) bool {
+			return len(cfg.PrefetchDoms) == 2 && cfg.PrefetchDoms[0] == "hello123" && cfg.PrefetchDoms[1] == "DEFAULT_GROUP@@world456"
+		}},
+		{"nacos {\n prefetch file testdata/no-such.txt\n}", "prefetch file testdata/no-such.txt: open testdata/no-such.txt: no such file or directory", nil},
+		{"nacos {\n prefetch file\n}", "Wrong argument count", nil},
+		{"nacos {\n prefetch testdata/prefetch.txt\n}", "", func(cfg *Config) bool { return cfg.PrefetchDoms[0] == "testdata/prefetch.txt" }},
+		{"nacos {\n prefetch\n prefetch_timeout 0s\n}", "invalid prefetch_timeout '0s'", nil},
+		{"nacos {\n prefetch_timeout 3s\n}", "Testfile:2 - Error during parsing: prefetch_timeout requires prefetch", nil},
+		// name_to_service, service_to_name
+		{"nacos {\n name_to_service ^(.+)\\.dubbo$ providers:$1 DEFAULT_GROUP dev\n name_to_service ^(.+)\\.svc$ $1\n service_to_name ^providers:(.+)$ $1.dubbo\n}", "",
+			func(cfg *Config) bool {
+				return len(cfg.NameRules) == 2 && cfg.NameRules[0].Group == "DEFAULT_GROUP" && cfg.NameRules[0].Namespace == "dev" &&
+					cfg.NameRules[1].Group == "" && len(cfg.ServiceRules) == 1 && cfg.ServiceRules[0].Name == "$1.dubbo"
+			}},
+		{"nacos {\n name_to_service ^(.+)\\.dubbo$ providers:$1\n}", "",
+			func(cfg *Config) bool {
+				service, ok := NameMapper{NameRules: cfg.NameRules}.ToService("com.foo.Bar.DUBBO")
+				return ok && service.Name == "providers:com.foo.Bar"
+			}},
+		{"nacos {\n name_to_service ^(.+$ $1\n}", "invalid name_to_service pattern '^(.+$'", nil},
+		{"nacos {\n name_to_service ^(.+)$\n}", "Wrong argument count", nil},
+		{"nacos {\n service_to_name ^(.+)$ $1 extra\n}", "Wrong argument count", nil},
+		{"nacos {\n service_to_name ^(.+$ $1\n}", "invalid service_to_name pattern '^(.+$'", nil},
+		// include, exclude
+		{"nacos {\n include * * public\n include * APP_GROUP\n exclude admin-*\n exclude /^internal\\./\n}", "",
+			func(cfg *Config) bool {
+				return len(cfg.Filter.Include) == 2 && len(cfg.Filter.Exclude) == 2 &&
+					!cfg.Filter.Allowed(Service{Name: "admin-console"}) && cfg.Filter.Allowed(Service{Name: "hello123"})
+			}},
+		{"nacos {\n exclude\n}", "Wrong argument count", nil},
+		{"nacos {\n include a b c d\n}", "Wrong argument count", nil},
+		{"nacos {\n exclude [admin\n}", "invalid exclude pattern", nil},
+		{"nacos {\n include /(admin/\n}", "invalid include pattern", nil},
+		// health_window
+		{"nacos {\n health_window 2m\n}", "", func(cfg *Config) bool { return cfg.HealthWindow == 2*time.Minute }},
+		{"nacos {\n}", "", func(cfg *Config) bool { return cfg.HealthWindow == DefaultHealthWindow }},
+		{"nacos {\n health_window 10ms\n}", "invalid health_window '10ms'", nil},
+		// admin
+		{"nacos {\n admin 127.0.0.1:8053 secret\n}", "", func(cfg *Config) bool { return cfg.AdminAddr == "127.0.0.1:8053" && cfg.AdminToken == "secret" }},
+		{"nacos {\n admin :8053\n}", "", func(cfg *Config) bool { return cfg.AdminAddr == ":8053" && cfg.AdminToken == "" }},
+		{"nacos {\n admin 127.0.0.1\n}", "invalid admin address '127.0.0.1'", nil},
+		{"nacos {\n admin\n}", "Wrong argument count", nil},
+		// log_level, log_output, log_format, log_sample
+		{"nacos {\n log_level warn\n log_output stderr\n log_format json\n log_sample 0.01\n}", "",
+			func(cfg *Config) bool {
+				return cfg.LogLevel == "warn" && cfg.LogOutput == LogOutputStderr && cfg.LogFormat == LogFormatJSON && cfg.LogSample == 0.01
+			}},
+		{"nacos {\n log_output clog\n log_format text\n}", "", func(cfg *Config) bool { return cfg.LogOutput == LogOutputClog }},
+		{"nacos {\n}", "", func(cfg *Config) bool { return cfg.LogSample == 1 && cfg.LogOutput == "" }},
+		{"nacos {\n log_level verbose\n}", "unknown log_level 'verbose'", nil},
+		{"nacos {\n log_output syslog\n}", "unknown log_output 'syslog'", nil},
+		{"nacos {\n log_format xml\n}", "unknown log_format 'xml'", nil},
+		{"nacos {\n log_sample 2\n}", "invalid log_sample '2'", nil},
+		{"nacos {\n log_path /tmp/nacos-logs\n log_output stderr\n}", "Testfile:2 - Error during parsing: log_path requires log_output file", nil},
+		{"nacos {\n log_output clog\n log_format json\n}", "Testfile:3 - Error during parsing: log_format json conflicts with log_output clog", nil},
+		// register
+		{"nacos {\n register\n}", "", func(cfg *Config) bool { return cfg.Register && cfg.RegisterService.Key() == DefaultRegisterService }},
+		{"nacos {\n register dns-agent INFRA dev\n}", "", func(cfg *Config) bool { return cfg.RegisterService.Key() == "dev##INFRA@@dns-agent" }},
+		{"nacos {\n register a b c d\n}", "Wrong argument count", nil},
+		// tsig_key
+		{"nacos {\n tsig_key Update hmac-sha256 c2VjcmV0\n tsig_key other. hmac-sha512. b3RoZXI=\n}", "", func(cfg *Config) bool {
+			return len(cfg.TsigKeys) == 2 && cfg.TsigKeys["update."].Algorithm == dns.HmacSHA256 && cfg.TsigKeys["other."].Secret == "b3RoZXI="
+		}},
+		{"nacos {\n tsig_key update. hmac-sha256 c2VjcmV0\n tsig_key update hmac-sha1 c2VjcmV0\n}", "duplicate tsig_key 'update.'", nil},
+		{"nacos {\n tsig_key update. rsa c2VjcmV0\n}", "unsupported TSIG algorithm rsa.", nil},
+		{"nacos {\n tsig_key update. hmac-sha256 not-base64!\n}", "TSIG secret is not base64", nil},
+		{"nacos {\n tsig_key update. hmac-sha256\n}", "Wrong argument count", nil},
+		// registry_file
+		{"nacos {\n registry_file testdata/registry.yaml\n upstream 8.8.8.8\n}", "", func(cfg *Config) bool { return cfg.RegistryFile == "testdata/registry.yaml" }},
+		{"nacos {\n registry_file testdata/no-such.yaml\n}", "failed to read registry_file 'testdata/no-such.yaml'", nil},
+		{"nacos {\n registry_file testdata/push-v1.json\n}", "invalid registry_file 'testdata/push-v1.json'", nil},
+		{"nacos {\n registry_file testdata/registry.yaml\n nacos_server 192.168.0.1\n}", "Testfile:3 - Error during parsing: nacos_server conflicts with registry_file", nil},
+		{"nacos {\n admin :8053\n registry_file testdata/registry.yaml\n}", "Testfile:2 - Error during parsing: admin conflicts with registry_file", nil},
+		// zones and unknown properties
+		{"nacos nacos.local {\n}", "", func(cfg *Config) bool { return cfg.Zones[0] == "nacos.local." }},
+		{"nacos {\n nacos_sever 192.168.0.1\n}", "Testfile:2 - Error during parsing: unknown property 'nacos_sever'", nil},
+		{"nacos\nnacos", "nacos can only be used once per server block", nil},
+	}
+
+	for i, test := range tests {
+		c := caddy.NewTestController("dns", test.input)
+		cfg, err := ParseConfig(c)
+
+		if test.expectedErrContent == "" {
+			if err != nil {
+				t.Errorf("Test %d: expected no error, got %v", i, err)
+			} else if test.check != nil && !test.check(cfg) {
+				t.Errorf("Test %d: unexpected config %+v", i, cfg)
+			}
+			continue
+		}
+
+		if err == nil {
+			t.Errorf("Test %d: expected error containing %q, got none", i, test.expectedErrContent)
+		} else if !strings.Contains(err.Error(), test.expectedErrContent) {
+			t.Errorf("Test %d: expected error containing %q, got %v", i, test.expectedErrContent, err)
+		}
+	}
+}
+
+func TestNacosParse_Logger(t *testing.T) {
+	global := NacosClientLogger
+	c := caddy.NewTestController("dns", "nacos {\n nacos_server 192.168.0.1\n upstream 8.8.8.8\n log_output stderr\n log_level error\n}")
+	vs, err := NacosParse(c)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// every block logs to its own logger
+	if vs.Logger == nil || vs.NacosClientImpl.Logger() != vs.Logger || vs.Upstream.Logger != vs.Logger || vs.NacosClientImpl.GetServerManager().log() != vs.Logger {
+		t.Fatal("expected the plugin, client, server list and upstream to share the logger of the block")
+	}
+	if NacosClientLogger != global {
+		t.Fatal("expected the package logger to be left alone")
+	}
+}
@@ -0,0 +1,276 @@
+/*
+ * Copyright 1999-2018 Alibaba Group Holding Ltd.
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *      http://www.apache.org/licenses/LICENSE-2.0
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package nacos
+
+import (
+	"context"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"os"
+	"sort"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/cihub/seelog"
+	"gopkg.in/yaml.v2"
+)
+
+// StaticReloadInterval is how often a started StaticDiscovery checks its
+// file for changes.
+var StaticReloadInterval = 5 * time.Second
+
+// StaticDiscovery is a ServiceDiscovery serving the services of a YAML
+// file instead of nacos, e.g. in dev environments:
+//
+//	services:
+//	  hello123:
+//	    - ip: 2.2.2.2
+//	      port: 80
+//	  dev##DEFAULT_GROUP@@world456:
+//	    - ip: 5.5.5.5
+//	      port: 8080
+//	      weight: 2
+//	    - ip: 6.6.6.6
+//	      port: 8080
+//	      valid: false
+//
+// Instances are valid with weight 1 unless set otherwise, and the same for
+// all clients. Once started, the file is read again when it is modified.
+type StaticDiscovery struct {
+	path      string
+	lock      sync.RWMutex
+	domains   map[string]Domain
+	modTime   time.Time
+	index     map[string]int
+	listeners listeners
+	cancel    context.CancelFunc
+	wg        sync.WaitGroup
+	logger    seelog.LoggerInterface
+}
+
+type staticFile struct {
+	Services map[string][]staticInstance `yaml:"services"`
+}
+
+type staticInstance struct {
+	IP     string   `yaml:"ip"`
+	Port   int      `yaml:"port"`
+	Weight *float64 `yaml:"weight"`
+	Valid  *bool    `yaml:"valid"`
+}
+
+// NewStaticDiscovery returns the registry of the YAML file at path, it
+// fails if the file cannot be read or is invalid. It logs to logger, or to
+// NacosClientLogger if logger is nil.
+func NewStaticDiscovery(path string, logger seelog.LoggerInterface) (*StaticDiscovery, error) {
+	if logger == nil {
+		logger = NacosClientLogger
+	}
+	sd := &StaticDiscovery{path: path, domains: make(map[string]Domain), index: make(map[string]int), logger: logger}
+	if err := sd.Reload(); err != nil {
+		return nil, err
+	}
+	return sd, nil
+}
+
+// ParseStaticServices returns the services in the YAML data of a static
+// registry by key.
+func ParseStaticServices(data []byte) (map[string]Domain, error) {
+	var file staticFile
+	if err := yaml.UnmarshalStrict(data, &file); err != nil {
+		return nil, err
+	}
+
+	domains := make(map[string]Domain)
+	for dom, instances := range file.Services {
+		if ParseServiceKey(dom).Name == "" {
+			return nil, fmt.Errorf("service '%s' has no name", dom)
+		}
+
+		domain := Domain{Name: dom, Instances: make([]Instance, 0, len(instances))}
+		for _, si := range instances {
+			if net.ParseIP(si.IP) == nil {
+				return nil, fmt.Errorf("invalid ip '%s' of service '%s'", si.IP, dom)
+			}
+			if si.Port < 0 || si.Port > 65535 {
+				return nil, fmt.Errorf("invalid port %d of service '%s'", si.Port, dom)
+			}
+
+			instance := Instance{IP: si.IP, Port: si.Port, Weight: 1, Valid: true}
+			if si.Weight != nil {
+				if *si.Weight < 0 {
+					return nil, fmt.Errorf("negative weight of %s in service '%s'", si.IP, dom)
+				}
+				instance.Weight = *si.Weight
+			}
+			if si.Valid != nil {
+				instance.Valid = *si.Valid
+			}
+			domain.Instances = append(domain.Instances, instance)
+		}
+		domains[dom] = domain
+	}
+	return domains, nil
+}
+
+// Reload reads the file again and notifies the listeners of the services
+// that changed. The services are kept if the file is invalid.
+func (sd *StaticDiscovery) Reload() error {
+	info, err := os.Stat(sd.path)
+	if err != nil {
+		return err
+	}
+	b, err := ioutil.ReadFile(sd.path)
+	if err != nil {
+		return err
+	}
+	domains, err := ParseStaticServices(b)
+	if err != nil {
+		return fmt.Errorf("%s: %v", sd.path, err)
+	}
+
+	sd.lock.Lock()
+	old := sd.domains
+	sd.domains = domains
+	sd.modTime = info.ModTime()
+	sd.lock.Unlock()
+
+	sd.logger.Info("static services are loaded from " + sd.path + ", total: " + strconv.Itoa(len(domains)))
+
+	for dom, domain := range domains {
+		sd.listeners.notify(sd.logger, dom, "", old[dom].Instances, domain.Instances)
+	}
+	for dom, domain := range old {
+		if _, ok := domains[dom]; !ok {
+			sd.listeners.notify(sd.logger, dom, "", domain.Instances, nil)
+		}
+	}
+	return nil
+}
+
+// Start reloads the file whenever it is modified until ctx is done or
+// Stop is called.
+func (sd *StaticDiscovery) Start(ctx context.Context) error {
+	ctx, sd.cancel = context.WithCancel(ctx)
+	sd.wg.Add(1)
+	go func() {
+		defer sd.wg.Done()
+		sd.watch(ctx)
+	}()
+	return nil
+}
+
+// Stop stops the reloads started by Start.
+func (sd *StaticDiscovery) Stop() error {
+	if sd.cancel != nil {
+		sd.cancel()
+		sd.wg.Wait()
+	}
+	return nil
+}
+
+func (sd *StaticDiscovery) watch(ctx context.Context) {
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(StaticReloadInterval):
+		}
+
+		info, err := os.Stat(sd.path)
+		if err != nil {
+			sd.logger.Warn("failed to check static services: ", err)
+			continue
+		}
+
+		sd.lock.RLock()
+		modified := !info.ModTime().Equal(sd.modTime)
+		sd.lock.RUnlock()
+		if !modified {
+			continue
+		}
+
+		if err := sd.Reload(); err != nil {
+			sd.logger.Error("failed to reload static services: ", err)
+		}
+	}
+}
+
+// Domain returns the service dom of the file.
+func (sd *StaticDiscovery) Domain(dom string) (Domain, bool) {
+	sd.lock.RLock()
+	defer sd.lock.RUnlock()
+
+	domain, ok := sd.domains[dom]
+	return domain, ok
+}
+
+// Managed implements ServiceDiscovery, the services of the file are managed.
+func (sd *StaticDiscovery) Managed(dom, clientIP string) bool {
+	_, ok := sd.Domain(dom)
+	return ok
+}
+
+// SrvInstances implements ServiceDiscovery.
+func (sd *StaticDiscovery) SrvInstances(dom, clientIP string) []Instance {
+	domain, _ := sd.Domain(dom)
+	return domain.SrvInstances()
+}
+
+// SrvInstanceContext implements ServiceDiscovery, the instances are
+// returned round robin.
+func (sd *StaticDiscovery) SrvInstanceContext(ctx context.Context, dom, clientIP string) *Instance {
+	hosts := sd.SrvInstances(dom, clientIP)
+	if len(hosts) == 0 {
+		return nil
+	}
+
+	sd.lock.Lock()
+	index := sd.index[dom] % len(hosts)
+	sd.index[dom] = index + 1
+	sd.lock.Unlock()
+
+	return &hosts[index]
+}
+
+// DomsByIP implements ServiceDiscovery.
+func (sd *StaticDiscovery) DomsByIP(ip string) []string {
+	sd.lock.RLock()
+	defer sd.lock.RUnlock()
+
+	var doms []string
+	for dom, domain := range sd.domains {
+		for _, instance := range domain.Instances {
+			if instance.Valid && instance.Weight > 0 && instance.IP == ip {
+				doms = append(doms, dom)
+				break
+			}
+		}
+	}
+	sort.Strings(doms)
+	return doms
+}
+
+// Subscribe implements ServiceDiscovery. The listener is called when a
+// reload of the file changes the instances of dom.
+func (sd *StaticDiscovery) Subscribe(dom string, listener ServiceListener) int {
+	return sd.listeners.subscribe(dom, listener)
+}
+
+// Unsubscribe implements ServiceDiscovery.
+func (sd *StaticDiscovery) Unsubscribe(dom string, id int) {
+	sd.listeners.unsubscribe(dom, id)
+}
@@ -0,0 +1,144 @@
+/*
+ * Copyright 1999-2018 Alibaba Group Holding Ltd.
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *      http://www.apache.org/licenses/LICENSE-2.0
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package nacos
+
+import (
+	"context"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestParseStaticServices(t *testing.T) {
+	b, err := ioutil.ReadFile("testdata/registry.yaml")
+	if err != nil {
+		t.Fatal(err)
+	}
+	domains, err := ParseStaticServices(b)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	expected := map[string]Domain{
+		"hello123": {Name: "hello123", Instances: []Instance{{IP: "2.2.2.2", Port: 80, Weight: 1, Valid: true}}},
+		"dev##DEFAULT_GROUP@@world456": {Name: "dev##DEFAULT_GROUP@@world456", Instances: []Instance{
+			{IP: "5.5.5.5", Port: 8080, Weight: 2, Valid: true}, {IP: "6.6.6.6", Port: 8080, Weight: 1, Valid: false}}},
+	}
+	if !reflect.DeepEqual(domains, expected) {
+		t.Fatalf("unexpected services %+v", domains)
+	}
+
+	tests := []struct {
+		input              string
+		expectedErrContent string
+	}{
+		{"services:\n  hello123:\n    - ip: 2.2.2.256\n", "invalid ip '2.2.2.256' of service 'hello123'"},
+		{"services:\n  hello123:\n    - ip: 2.2.2.2\n      port: 65536\n", "invalid port 65536"},
+		{"services:\n  hello123:\n    - ip: 2.2.2.2\n      weight: -1\n", "negative weight"},
+		{"services:\n  DEFAULT_GROUP@@:\n    - ip: 2.2.2.2\n", "has no name"},
+		{"services:\n  hello123:\n    - ip: 2.2.2.2\n      prot: 80\n", "field prot not found"},
+	}
+	for i, test := range tests {
+		if _, err := ParseStaticServices([]byte(test.input)); err == nil || !strings.Contains(err.Error(), test.expectedErrContent) {
+			t.Errorf("Test %d: expected error containing %q, got %v", i, test.expectedErrContent, err)
+		}
+	}
+}
+
+func TestStaticDiscovery(t *testing.T) {
+	sd, err := NewStaticDiscovery("testdata/registry.yaml", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if !sd.Managed("hello123", "10.0.0.1") || sd.Managed("world456", "10.0.0.1") {
+		t.Fatal("expected only the services of the file to be managed")
+	}
+	if hosts := sd.SrvInstances("dev##DEFAULT_GROUP@@world456", ""); len(hosts) != 2 || hosts[0].IP != "5.5.5.5" || hosts[1].IP != "5.5.5.5" {
+		t.Fatalf("expected the valid instance twice for its weight, got %v", hosts)
+	}
+	if host := sd.SrvInstanceContext(context.TODO(), "no-such-service", ""); host != nil {
+		t.Fatalf("expected no instance of an unknown service, got %v", host)
+	}
+	if doms := sd.DomsByIP("5.5.5.5"); !reflect.DeepEqual(doms, []string{"dev##DEFAULT_GROUP@@world456"}) {
+		t.Fatalf("unexpected doms %v", doms)
+	}
+	if doms := sd.DomsByIP("6.6.6.6"); len(doms) != 0 {
+		t.Fatalf("expected no doms for an invalid instance, got %v", doms)
+	}
+}
+
+func TestStaticDiscovery_Reload(t *testing.T) {
+	dir, err := ioutil.TempDir("", "nacos-static")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	path := filepath.Join(dir, "registry.yaml")
+	write := func(content string) {
+		if err := ioutil.WriteFile(path, []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	write("services:\n  hello123:\n    - ip: 2.2.2.2\n      port: 80\n    - ip: 3.3.3.3\n      port: 80\n")
+
+	sd, err := NewStaticDiscovery(path, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if a, b := sd.SrvInstanceContext(context.TODO(), "hello123", ""), sd.SrvInstanceContext(context.TODO(), "hello123", ""); a.IP == b.IP {
+		t.Fatalf("expected the instances round robin, got %v twice", a)
+	}
+
+	var events []ServiceEvent
+	id := sd.Subscribe("hello123", func(event ServiceEvent) { events = append(events, event) })
+
+	// unchanged services do not notify
+	if err := sd.Reload(); err != nil || len(events) != 0 {
+		t.Fatalf("expected no events, got %v %v", events, err)
+	}
+
+	write("services:\n  hello123:\n    - ip: 2.2.2.2\n      port: 81\n")
+	if err := sd.Reload(); err != nil {
+		t.Fatal(err)
+	}
+	if len(events) != 1 || len(events[0].Old) != 2 || !reflect.DeepEqual(events[0].New, []Instance{{IP: "2.2.2.2", Port: 81, Weight: 1, Valid: true}}) {
+		t.Fatalf("unexpected events %+v", events)
+	}
+
+	// an invalid file keeps the services
+	write("services:\n  hello123:\n    - ip: nowhere\n")
+	if err := sd.Reload(); err == nil || !sd.Managed("hello123", "") {
+		t.Fatalf("expected the services to be kept, got %v", err)
+	}
+
+	write("services: {}\n")
+	if err := sd.Reload(); err != nil {
+		t.Fatal(err)
+	}
+	if len(events) != 2 || events[1].Dom != "hello123" || len(events[1].New) != 0 {
+		t.Fatalf("expected an event for the removed service, got %+v", events)
+	}
+
+	sd.Unsubscribe("hello123", id)
+	write("services:\n  hello123:\n    - ip: 2.2.2.2\n")
+	sd.Reload()
+	if len(events) != 2 {
+		t.Fatalf("expected no events after unsubscribe, got %+v", events)
+	}
+}
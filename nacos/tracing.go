@@ -0,0 +1,42 @@
+/*
+ * Copyright 1999-2018 Alibaba Group Holding Ltd.
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *      http://www.apache.org/licenses/LICENSE-2.0
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package nacos
+
+import (
+	"context"
+
+	ot "github.com/opentracing/opentracing-go"
+)
+
+// names of the spans created by the plugin
+const (
+	SpanServeDNS = "nacos"
+	SpanManaged  = "nacos.managed"
+	SpanCache    = "nacos.cache"
+	SpanRequest  = "nacos.request"
+	SpanForward  = "nacos.forward"
+)
+
+// startSpan starts a child of the span in ctx, the root span is created by
+// the trace plugin with its tracer. Without a span in ctx nothing is traced
+// and a no-op span is returned.
+func startSpan(ctx context.Context, name string) (ot.Span, context.Context) {
+	parent := ot.SpanFromContext(ctx)
+	if parent == nil {
+		return ot.NoopTracer{}.StartSpan(name), ctx
+	}
+
+	span := parent.Tracer().StartSpan(name, ot.ChildOf(parent.Context()))
+	return span, ot.ContextWithSpan(ctx, span)
+}
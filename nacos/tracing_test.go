@@ -0,0 +1,95 @@
+/*
+ * Copyright 1999-2018 Alibaba Group Holding Ltd.
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *      http://www.apache.org/licenses/LICENSE-2.0
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package nacos
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"testing"
+
+	"github.com/coredns/coredns/plugin/pkg/dnstest"
+	"github.com/coredns/coredns/plugin/test"
+	"github.com/miekg/dns"
+	ot "github.com/opentracing/opentracing-go"
+	"github.com/opentracing/opentracing-go/mocktracer"
+)
+
+func TestNacos_ServeDNSTracing(t *testing.T) {
+	s := `{"dom":"hello123","cacheMillis":10000,"hosts":[{"valid":true,"port":80,"ip":"2.2.2.2","weight":1.0}]}`
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		if req.URL.EscapedPath() == "/nacos/v1/ns/api/srvIPXT" {
+			w.Write([]byte(s))
+		}
+	}))
+	defer server.Close()
+
+	upstream, stop := startUpstream(t, "3.3.3.3", false)
+	defer stop()
+
+	host := strings.TrimPrefix(server.URL, "http://")
+	port, _ := strconv.Atoi(strings.Split(host, ":")[1])
+	client := &NacosClient{domainMap: NewConcurrentMap(), indexMap: NewConcurrentMap(), cachePath: t.TempDir(), serverPort: port}
+	client.udpServer.vipClient = client
+	client.SetServers([]string{strings.Split(host, ":")[0]})
+	client.allDoms.Data = map[string]bool{"hello123": true}
+	vs := Nacos{NacosClientImpl: client, DNSCache: NewConcurrentMap(), Upstream: NewForwarder([]string{upstream})}
+
+	tracer := mocktracer.New()
+	serve := func(name string) map[string]*mocktracer.MockSpan {
+		tracer.Reset()
+		root := tracer.StartSpan("servedns")
+		r := new(dns.Msg)
+		r.SetQuestion(name, dns.TypeA)
+		vs.ServeDNS(ot.ContextWithSpan(context.TODO(), root), dnstest.NewRecorder(&test.ResponseWriter{}), r)
+		root.Finish()
+
+		spans := make(map[string]*mocktracer.MockSpan)
+		for _, span := range tracer.FinishedSpans() {
+			spans[span.OperationName] = span
+		}
+		return spans
+	}
+
+	// a cache miss queries nacos
+	spans := serve("hello123.")
+	for _, name := range []string{SpanServeDNS, SpanManaged, SpanCache, SpanRequest} {
+		if spans[name] == nil {
+			t.Fatalf("expected span %s, got %v", name, spans)
+		}
+	}
+	if spans[SpanCache].Tag("hit") != false {
+		t.Errorf("expected cache miss, got %v", spans[SpanCache].Tags())
+	}
+	if spans[SpanRequest].Tag("server") != host || spans[SpanRequest].Tag("http.status_code") != uint16(200) {
+		t.Errorf("unexpected request tags %v", spans[SpanRequest].Tags())
+	}
+	if spans[SpanRequest].ParentID != spans[SpanCache].SpanContext.SpanID {
+		t.Error("expected the request to be a child of the cache lookup")
+	}
+
+	// a cache hit does not
+	spans = serve("hello123.")
+	if spans[SpanCache].Tag("hit") != true || spans[SpanRequest] != nil {
+		t.Errorf("expected cache hit without request, got %v", spans)
+	}
+
+	// names not in nacos are forwarded
+	spans = serve("www.example.org.")
+	if spans[SpanForward] == nil || spans[SpanServeDNS].Tag("source") != SourceUpstream {
+		t.Errorf("expected forward span, got %v", spans)
+	}
+}
@@ -0,0 +1,231 @@
+/*
+ * Copyright 1999-2018 Alibaba Group Holding Ltd.
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *      http://www.apache.org/licenses/LICENSE-2.0
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package nacos
+
+import (
+	"bytes"
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"io"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/coredns/coredns/plugin/pkg/parse"
+	"github.com/miekg/dns"
+)
+
+const (
+	TransportDNS   = "dns"
+	TransportTLS   = "tls"
+	TransportHTTPS = "https"
+
+	dohMediaType = "application/dns-message"
+)
+
+var (
+	// idle connections kept per DNS-over-TLS or DNS-over-HTTPS upstream
+	MaxIdleTLSConns = 8
+	TLSIdleTimeout  = 30 * time.Second
+)
+
+// ParseUpstreams normalizes the upstream arguments of the Corefile.
+// tls://host[:port] and https:// URLs are kept with their scheme, the
+// port of tls:// defaults to 853 and the path of https:// to /dns-query.
+// Everything else is a plain DNS host[:port] or a resolv.conf like file.
+func ParseUpstreams(args ...string) ([]string, error) {
+	var ups []string
+
+	for _, arg := range args {
+		switch {
+		case strings.HasPrefix(arg, "tls://"):
+			host := strings.TrimPrefix(arg, "tls://")
+			if host == "" {
+				return nil, NacosClientError{"invalid upstream: " + arg}
+			}
+			if _, _, err := net.SplitHostPort(host); err != nil {
+				host = net.JoinHostPort(host, "853")
+			}
+			ups = append(ups, "tls://"+host)
+		case strings.HasPrefix(arg, "https://"):
+			u, err := url.Parse(arg)
+			if err != nil || u.Host == "" {
+				return nil, NacosClientError{"invalid upstream: " + arg}
+			}
+			if u.Path == "" {
+				u.Path = "/dns-query"
+			}
+			ups = append(ups, u.String())
+		default:
+			hosts, err := parse.HostPortOrFile(strings.TrimPrefix(arg, "dns://"))
+			if err != nil {
+				return nil, err
+			}
+			ups = append(ups, hosts...)
+		}
+	}
+
+	return ups, nil
+}
+
+// NewTLSConfig returns the TLS config for an encrypted upstream. The server
+// certificate is verified against serverName and, if caFile is not empty,
+// against the CAs in caFile instead of the system roots.
+func NewTLSConfig(serverName, caFile string) (*tls.Config, error) {
+	cfg := &tls.Config{
+		ServerName:         serverName,
+		ClientSessionCache: tls.NewLRUClientSessionCache(0),
+	}
+
+	if caFile != "" {
+		pem, err := ioutil.ReadFile(caFile)
+		if err != nil {
+			return nil, err
+		}
+		pool := x509.NewCertPool()
+		if !pool.AppendCertsFromPEM(pem) {
+			return nil, NacosClientError{"no certificate found in " + caFile}
+		}
+		cfg.RootCAs = pool
+	}
+
+	return cfg, nil
+}
+
+// SetTLSConfig sets the TLS config used by DNS-over-TLS and DNS-over-HTTPS
+// upstreams. Idle connections made with the previous config are closed.
+func (u *Upstream) SetTLSConfig(cfg *tls.Config) {
+	u.tlsConfig = cfg
+	u.closeIdle()
+
+	if u.transport == TransportHTTPS {
+		u.httpClient = &http.Client{Transport: &http.Transport{
+			TLSClientConfig:     cfg,
+			MaxIdleConnsPerHost: MaxIdleTLSConns,
+			IdleConnTimeout:     TLSIdleTimeout,
+		}}
+	}
+}
+
+// closeIdle closes the pooled connections of the upstream.
+func (u *Upstream) closeIdle() {
+	if u.conns != nil {
+	drain:
+		for {
+			select {
+			case conn := <-u.conns:
+				conn.Close()
+			default:
+				break drain
+			}
+		}
+	}
+
+	if u.httpClient != nil {
+		if t, ok := u.httpClient.Transport.(*http.Transport); ok {
+			t.CloseIdleConnections()
+		}
+	}
+}
+
+// exchangeTLS sends m over a pooled TLS connection. A pooled connection may
+// have been closed by the server meanwhile, so on failure the next pooled or
+// a new connection is tried.
+func (u *Upstream) exchangeTLS(m *dns.Msg, timeout time.Duration) (*dns.Msg, error) {
+	for {
+		var conn *dns.Conn
+		reused := true
+
+		select {
+		case conn = <-u.conns:
+		default:
+			reused = false
+			c, err := dns.DialTimeoutWithTLS("tcp-tls", u.addr, u.tlsConfig, timeout)
+			if err != nil {
+				return nil, err
+			}
+			conn = c
+		}
+
+		conn.SetDeadline(time.Now().Add(timeout))
+		err := conn.WriteMsg(m)
+		var reply *dns.Msg
+		if err == nil {
+			reply, err = conn.ReadMsg()
+		}
+
+		if err != nil {
+			conn.Close()
+			if reused {
+				continue
+			}
+			return nil, err
+		}
+
+		select {
+		case u.conns <- conn:
+		default:
+			conn.Close()
+		}
+		return reply, nil
+	}
+}
+
+// exchangeHTTPS sends m as a RFC 8484 POST request, connections are reused
+// by the http client.
+func (u *Upstream) exchangeHTTPS(m *dns.Msg, timeout time.Duration) (*dns.Msg, error) {
+	buf, err := m.Pack()
+	if err != nil {
+		return nil, err
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+
+	req, err := http.NewRequest("POST", u.addr, bytes.NewReader(buf))
+	if err != nil {
+		return nil, err
+	}
+	req = req.WithContext(ctx)
+	req.Header.Set("Content-Type", dohMediaType)
+	req.Header.Set("Accept", dohMediaType)
+
+	resp, err := u.httpClient.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, NacosClientError{"unexpected status from " + u.addr + ": " + strconv.Itoa(resp.StatusCode)}
+	}
+
+	body, err := ioutil.ReadAll(io.LimitReader(resp.Body, dns.MaxMsgSize))
+	if err != nil {
+		return nil, err
+	}
+
+	reply := new(dns.Msg)
+	if err := reply.Unpack(body); err != nil {
+		return nil, err
+	}
+	reply.Id = m.Id
+
+	return reply, nil
+}
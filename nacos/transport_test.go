@@ -0,0 +1,80 @@
+/*
+ * Copyright 1999-2018 Alibaba Group Holding Ltd.
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *      http://www.apache.org/licenses/LICENSE-2.0
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package nacos
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"testing"
+
+	"github.com/miekg/dns"
+)
+
+func TestParseUpstreams(t *testing.T) {
+	ups, err := ParseUpstreams("8.8.8.8", "tls://1.1.1.1", "tls://9.9.9.9:8853", "https://dns.google", "https://doh.example/query")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	expected := []string{"8.8.8.8:53", "tls://1.1.1.1:853", "tls://9.9.9.9:8853", "https://dns.google/dns-query", "https://doh.example/query"}
+	if !reflect.DeepEqual(ups, expected) {
+		t.Fatalf("expected %v, got %v", expected, ups)
+	}
+
+	if _, err := ParseUpstreams("https://"); err == nil {
+		t.Fatal("expected error for an upstream without host")
+	}
+}
+
+func TestUpstream_ExchangeHTTPS(t *testing.T) {
+	server := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		if req.Header.Get("Content-Type") != dohMediaType || req.URL.Path != "/dns-query" {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+
+		body, _ := ioutil.ReadAll(req.Body)
+		r := new(dns.Msg)
+		r.Unpack(body)
+
+		m := new(dns.Msg)
+		m.SetReply(r)
+		rr, _ := dns.NewRR(r.Question[0].Name + " 10 IN A 2.2.2.2")
+		m.Answer = append(m.Answer, rr)
+		buf, _ := m.Pack()
+
+		w.Header().Set("Content-Type", dohMediaType)
+		w.Write(buf)
+	}))
+	defer server.Close()
+
+	ups, _ := ParseUpstreams(server.URL)
+	f := NewForwarder(ups)
+
+	pool := x509.NewCertPool()
+	pool.AddCert(server.Certificate())
+	f.Upstreams()[0].SetTLSConfig(&tls.Config{RootCAs: pool})
+
+	reply, err := f.Exchange(query("example.org."), "udp")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(reply.Answer) != 1 || reply.Answer[0].(*dns.A).A.String() != "2.2.2.2" {
+		t.Fatalf("unexpected answer %v", reply.Answer)
+	}
+}
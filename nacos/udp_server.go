@@ -20,12 +20,17 @@ import (
 	"math/rand"
 	json "encoding/json"
 	"time"
+	"sync/atomic"
+
+	"github.com/nacos-group/nacos-coredns-plugin/nacos/nacoscache"
 )
 
 type UDPServer struct {
 	port int
 	host string
 	vipClient *NacosClient
+	conn *net.UDPConn
+	closed int32
 }
 
 type PushData struct {
@@ -42,13 +47,13 @@ func getUdpPort() int {
 func (us *UDPServer) tryListen() (*net.UDPConn, bool) {
 	addr, err := net.ResolveUDPAddr("udp", us.host+":"+ strconv.Itoa(us.port))
 	if err != nil {
-		NacosClientLogger.Error("Can't resolve address: ", err)
+		us.vipClient.Logger().Error("Can't resolve address: ", err)
 		return nil , false
 	}
 
 	conn, err := net.ListenUDP("udp", addr)
 	if err != nil {
-		NacosClientLogger.Error("Error listening:", err)
+		us.vipClient.Logger().Error("Error listening:", err)
 		return nil, false
 	}
 
@@ -60,63 +65,116 @@ func (us *UDPServer) SetNacosClient(nc *NacosClient) {
 }
 
 func (us *UDPServer) StartServer(){
-	var conn *net.UDPConn
+	if err := us.Listen(); err != nil {
+		us.vipClient.Logger().Critical(err.Error())
+		os.Exit(1)
+	}
+
+	us.Serve()
+}
 
+// Listen binds the push listener to a random port, trying 3 ports at most.
+func (us *UDPServer) Listen() error {
 	for i := 0; i < 3; i++ {
 		r := rand.New(rand.NewSource(time.Now().UnixNano()))
 		port := r.Intn(1000) + 54951
 		us.port = port
-		conn1, ok := us.tryListen()
+		conn, ok := us.tryListen()
 
 		if ok {
-			conn = conn1
-			NacosClientLogger.Info("udp server start, port: " + strconv.Itoa(port))
-			break
-		}
-
-		if !ok && i == 2 {
-			NacosClientLogger.Critical("failed to start udp server after trying 3 times.")
-			os.Exit(1)
+			us.conn = conn
+			atomic.StoreInt32(&us.closed, 0)
+			us.vipClient.Logger().Info("udp server start, port: " + strconv.Itoa(port))
+			return nil
 		}
 	}
 
-	UDP_Port = us.port
+	return NacosClientError{"failed to start udp server after trying 3 times."}
+}
 
-	defer conn.Close()
-	for {
-		us.handleClient(conn)
+// Serve handles pushes until Close is called.
+func (us *UDPServer) Serve() {
+	defer us.conn.Close()
+	for atomic.LoadInt32(&us.closed) == 0 {
+		us.handleClient(us.conn)
 	}
 }
 
+// Close stops Serve and releases the port.
+func (us *UDPServer) Close() error {
+	if us.conn == nil || !atomic.CompareAndSwapInt32(&us.closed, 0, 1) {
+		return nil
+	}
+	return us.conn.Close()
+}
+
 func (us *UDPServer) handleClient(conn *net.UDPConn) {
 	data := make([]byte, 4024)
 	n, remoteAddr, err := conn.ReadFromUDP(data)
 	if err != nil {
-		NacosClientLogger.Error("failed to read UDP msg because of ", err)
+		if atomic.LoadInt32(&us.closed) == 1 {
+			return
+		}
+		us.vipClient.Logger().Error("failed to read UDP msg because of ", err)
 		return
 	}
 
-	s := TryDecompressData(data[:n])
+	PushCount.WithLabelValues(PushReceived).Inc()
+	us.vipClient.Logger().Info("receive push from: ", remoteAddr)
 
-	NacosClientLogger.Info("receive push: " + s + " from: ", remoteAddr)
+	ack, ok := us.handlePush(data[:n])
+	if !ok {
+		PushCount.WithLabelValues(PushRejected).Inc()
+		return
+	}
+
+	if _, err := conn.WriteToUDP(ack, remoteAddr); err == nil {
+		PushCount.WithLabelValues(PushAcked).Inc()
+	}
+}
+
+// handlePush caches the domain in a push and returns the ack, it reports
+// false for pushes that are rejected. data comes from the network, no
+// input may make it panic.
+func (us *UDPServer) handlePush(data []byte) ([]byte, bool) {
+	s := tryDecompressData(data, us.vipClient.Logger())
+
+	us.vipClient.Logger().Info("receive push: " + s)
 
 	var pushData PushData
 	err1 := json.Unmarshal([]byte(s), &pushData)
 	if err1 != nil {
-		NacosClientLogger.Warn("failed to process push data, ", err1)
-		return
+		us.vipClient.Logger().Warn("failed to process push data, ", err1)
+		return nil, false
 	}
 
-	domain, err1 := ProcessDomainString(pushData.Data)
-	NacosClientLogger.Info("receive domain: " , domain)
+	domain, err1 := processDomainString(pushData.Data, us.vipClient.Logger())
+	us.vipClient.Logger().Info("receive domain: " , domain)
 
-	if err1 != nil {
-		NacosClientLogger.Warn("failed to process push data: " + s, err1)
+	// the last instance of the dom is gone, which is an update like any other
+	if err1 != nil && err1 != nacoscache.ErrNoInstances {
+		us.vipClient.Logger().Warn("failed to process push data: " + s, err1)
+		return nil, false
+	}
+
+	if domain.Name == "" {
+		us.vipClient.Logger().Warn("push without dom: " + s)
+		return nil, false
 	}
 
 	key := GetCacheKey(domain.Name, LocalIP())
 
-	us.vipClient.domainMap.Set(key, domain)
+	var old []Instance
+	if item, ok := us.vipClient.domainMap.Get(key); ok {
+		old = item.(Domain).Instances
+	}
+	if err1 == nacoscache.ErrNoInstances {
+		// a dom without instances is not cached, like on a refresh
+		us.vipClient.Purge(key)
+	} else {
+		us.vipClient.domainMap.Set(key, domain)
+	}
+	us.vipClient.listeners.notify(us.vipClient.Logger(), domain.Name, LocalIP(), old, domain.Instances)
 
 	ack := make(map[string]string)
 	ack["type"] = "push-ack"
@@ -124,7 +182,5 @@ func (us *UDPServer) handleClient(conn *net.UDPConn) {
 	ack["data"] = ""
 
 	bs,_ := json.Marshal(ack)
-
-	conn.WriteToUDP(bs, remoteAddr)
+	return bs, true
 }
-
@@ -0,0 +1,312 @@
+/*
+ * Copyright 1999-2018 Alibaba Group Holding Ltd.
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *      http://www.apache.org/licenses/LICENSE-2.0
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package nacos
+
+import (
+	"context"
+	"encoding/base64"
+	"net"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/coredns/coredns/plugin"
+	"github.com/coredns/coredns/request"
+	"github.com/miekg/dns"
+)
+
+// fudge of the TSIG records of signed update responses, in seconds
+const tsigFudge = 300
+
+// TsigAlgorithms are the algorithms accepted for TSIG keys.
+var TsigAlgorithms = map[string]bool{
+	dns.HmacMD5:    true,
+	dns.HmacSHA1:   true,
+	dns.HmacSHA256: true,
+	dns.HmacSHA512: true,
+}
+
+// TsigKey is a key DNS UPDATE messages are signed with.
+type TsigKey struct {
+	Algorithm string
+	// base64 encoded
+	Secret string
+}
+
+// NewTsigKey validates algorithm and secret, the algorithm may be given without trailing dot.
+func NewTsigKey(algorithm, secret string) (TsigKey, error) {
+	algorithm = dns.Fqdn(strings.ToLower(algorithm))
+	if !TsigAlgorithms[algorithm] {
+		return TsigKey{}, NacosClientError{"unsupported TSIG algorithm " + algorithm}
+	}
+	if _, err := base64.StdEncoding.DecodeString(secret); err != nil {
+		return TsigKey{}, NacosClientError{"TSIG secret is not base64: " + err.Error()}
+	}
+	return TsigKey{Algorithm: algorithm, Secret: secret}, nil
+}
+
+// instanceUpdate is a change of the instances of a service requested by
+// an update message. An empty IP selects every instance of the family, a
+// negative port every port of the IP.
+type instanceUpdate struct {
+	service Service
+	add     bool
+	ip      string
+	family  int
+	port    int
+	weight  float64
+}
+
+// serveUpdate applies an RFC 2136 update of a zone of the plugin to the
+// instances in nacos. Only signed updates are accepted and prerequisites
+// are not supported. A/AAAA records are instances of the service their
+// name maps to, an SRV record of the same name gives their port and weight.
+// The whole update section is checked before nacos is changed, if applying
+// it fails halfway the instances already changed are restored.
+func (vs *Nacos) serveUpdate(ctx context.Context, state request.Request) (int, error) {
+	r := state.Req
+	m := new(dns.Msg)
+	m.SetReply(r)
+
+	t := r.IsTsig()
+	if t == nil || len(vs.TsigKeys) == 0 {
+		return vs.replyUpdate(state, m, dns.RcodeRefused, nil)
+	}
+	key, ok := vs.TsigKeys[strings.ToLower(t.Hdr.Name)]
+	if !ok || key.Algorithm != strings.ToLower(t.Algorithm) {
+		vs.logger().Warn("update signed with unknown key " + t.Hdr.Name + " from " + state.IP())
+		return vs.replyUpdate(state, m, dns.RcodeNotAuth, nil)
+	}
+	if err := verifyTsig(r, key.Secret); err != nil {
+		vs.logger().Warn("update with bad signature of "+t.Hdr.Name+" from "+state.IP()+": ", err)
+		return vs.replyUpdate(state, m, dns.RcodeNotAuth, nil)
+	}
+	signed := &tsigSigner{name: t.Hdr.Name, key: key, mac: t.MAC}
+
+	if len(r.Question) != 1 || r.Question[0].Qtype != dns.TypeSOA {
+		return vs.replyUpdate(state, m, dns.RcodeFormatError, signed)
+	}
+	zone := strings.ToLower(r.Question[0].Name)
+	if plugin.Zones(vs.Zones).Matches(zone) != zone {
+		return vs.replyUpdate(state, m, dns.RcodeNotAuth, signed)
+	}
+	if len(r.Answer) > 0 {
+		return vs.replyUpdate(state, m, dns.RcodeNotImplemented, signed)
+	}
+
+	updates, rcode := vs.parseUpdate(zone, r.Ns)
+	if rcode != dns.RcodeSuccess {
+		return vs.replyUpdate(state, m, rcode, signed)
+	}
+
+	var undo []instanceUpdate
+	for _, u := range updates {
+		applied, err := vs.applyUpdate(ctx, u)
+		undo = append(undo, applied...)
+		vs.NacosClientImpl.Invalidate(u.service.Key())
+		if err != nil {
+			vs.logger().Warn("failed to update "+u.service.Key()+", rolling back: ", err)
+			vs.rollback(undo)
+			return vs.replyUpdate(state, m, dns.RcodeServerFailure, signed)
+		}
+	}
+	return vs.replyUpdate(state, m, dns.RcodeSuccess, signed)
+}
+
+// parseUpdate translates the update section into instance updates.
+func (vs *Nacos) parseUpdate(zone string, rrs []dns.RR) ([]instanceUpdate, int) {
+	// SRV records do not stand alone, they give the port of the addresses of their name
+	srvs := make(map[string]*dns.SRV)
+	addrs := make(map[string]bool)
+	for _, rr := range rrs {
+		hdr := rr.Header()
+		if !dns.IsSubDomain(zone, strings.ToLower(hdr.Name)) {
+			return nil, dns.RcodeNotZone
+		}
+		switch rr := rr.(type) {
+		case *dns.SRV:
+			if hdr.Class == dns.ClassINET || hdr.Class == dns.ClassNONE {
+				srvs[strings.ToLower(hdr.Name)] = rr
+			}
+		case *dns.A, *dns.AAAA:
+			addrs[strings.ToLower(hdr.Name)] = true
+		}
+	}
+
+	updates := make([]instanceUpdate, 0)
+	for _, rr := range rrs {
+		hdr := rr.Header()
+		name := strings.ToLower(hdr.Name)
+		service, _ := vs.Mapper.ToService(strings.TrimSuffix(hdr.Name, "."))
+		u := instanceUpdate{service: service, port: -1, weight: 1}
+		if srv, ok := srvs[name]; ok {
+			u.port = int(srv.Port)
+			if srv.Weight > 0 {
+				u.weight = float64(srv.Weight)
+			}
+		}
+
+		switch hdr.Class {
+		case dns.ClassINET, dns.ClassNONE:
+			u.add = hdr.Class == dns.ClassINET
+			switch rr := rr.(type) {
+			case *dns.A:
+				u.ip, u.family = rr.A.String(), 1
+			case *dns.AAAA:
+				u.ip, u.family = rr.AAAA.String(), 2
+			case *dns.SRV:
+				if u.add && !addrs[name] {
+					return nil, dns.RcodeFormatError
+				}
+				continue
+			default:
+				return nil, dns.RcodeRefused
+			}
+			if u.add && u.port < 0 {
+				u.port = 0
+			}
+		case dns.ClassANY:
+			// deletes a whole RRset, the record carries no data
+			if hdr.Ttl != 0 || hdr.Rdlength != 0 {
+				return nil, dns.RcodeFormatError
+			}
+			switch hdr.Rrtype {
+			case dns.TypeA:
+				u.family = 1
+			case dns.TypeAAAA:
+				u.family = 2
+			case dns.TypeSRV, dns.TypeANY:
+			default:
+				return nil, dns.RcodeRefused
+			}
+			u.port = -1
+		default:
+			return nil, dns.RcodeFormatError
+		}
+		updates = append(updates, u)
+	}
+	return updates, dns.RcodeSuccess
+}
+
+// applyUpdate registers or deregisters the instances of u. Instances
+// registered over DNS are persistent, nothing sends beats for them. It
+// returns the updates that undo what was applied, also if it fails.
+func (vs *Nacos) applyUpdate(ctx context.Context, u instanceUpdate) ([]instanceUpdate, error) {
+	client := vs.NacosClientImpl
+	instances, err := client.Instances(ctx, u.service)
+	if err != nil {
+		return nil, err
+	}
+
+	if u.add {
+		// an instance that is registered again gets its old weight back
+		undo := instanceUpdate{service: u.service, ip: u.ip, port: u.port}
+		for _, instance := range instances {
+			if instance.IP == u.ip && instance.Port == u.port {
+				undo.add, undo.weight = true, instance.Weight
+			}
+		}
+		if err := client.RegisterInstance(ctx, u.service, u.ip, u.port, u.weight, nil, false); err != nil {
+			return nil, err
+		}
+		return []instanceUpdate{undo}, nil
+	}
+
+	var undo []instanceUpdate
+	for _, instance := range instances {
+		if u.ip != "" && u.ip != instance.IP || u.port >= 0 && u.port != instance.Port ||
+			u.family != 0 && u.family != ipFamily(instance.IP) {
+			continue
+		}
+		if err := client.DeregisterInstance(ctx, u.service, instance.IP, instance.Port, false); err != nil {
+			return undo, err
+		}
+		undo = append(undo, instanceUpdate{service: u.service, add: true, ip: instance.IP, port: instance.Port, weight: instance.Weight})
+	}
+	return undo, nil
+}
+
+// rollback applies the undo updates of applyUpdate, the latest first.
+// Restored instances are persistent and lose their metadata, nacos does
+// not list it. The request may be gone, so it is not part of its context.
+func (vs *Nacos) rollback(undo []instanceUpdate) {
+	client := vs.NacosClientImpl
+	for i := len(undo) - 1; i >= 0; i-- {
+		u := undo[i]
+		var err error
+		if u.add {
+			err = client.RegisterInstance(context.Background(), u.service, u.ip, u.port, u.weight, nil, false)
+		} else {
+			err = client.DeregisterInstance(context.Background(), u.service, u.ip, u.port, false)
+		}
+		if err != nil {
+			vs.logger().Error("failed to roll back "+u.ip+":"+strconv.Itoa(u.port)+" of "+u.service.Key()+": ", err)
+		}
+		client.Invalidate(u.service.Key())
+	}
+}
+
+// ipFamily returns 1 for IPv4 and 2 for IPv6 addresses, like request.Request.Family.
+func ipFamily(ip string) int {
+	if parsed := net.ParseIP(ip); parsed != nil && parsed.To4() == nil {
+		return 2
+	}
+	return 1
+}
+
+// verifyTsig checks the signature of r. The server does not hand plugins
+// the message as received, so r is packed again, with and without name
+// compression since the signer may have used either.
+func verifyTsig(r *dns.Msg, secret string) error {
+	compress := r.Compress
+	defer func() { r.Compress = compress }()
+
+	var err error
+	for _, c := range []bool{true, false} {
+		r.Compress = c
+		var buf []byte
+		if buf, err = r.Pack(); err != nil {
+			return err
+		}
+		if err = dns.TsigVerify(buf, secret, "", false); err == nil {
+			return nil
+		}
+	}
+	return err
+}
+
+// tsigSigner signs the response to an update with the key of the request.
+type tsigSigner struct {
+	name string
+	key  TsigKey
+	mac  string
+}
+
+func (vs *Nacos) replyUpdate(state request.Request, m *dns.Msg, rcode int, signer *tsigSigner) (int, error) {
+	m.SetRcode(state.Req, rcode)
+	if signer == nil {
+		state.W.WriteMsg(m)
+		return rcode, nil
+	}
+
+	m.SetTsig(signer.name, signer.key.Algorithm, tsigFudge, time.Now().Unix())
+	buf, _, err := dns.TsigGenerate(m, signer.key.Secret, signer.mac, false)
+	if err != nil {
+		return dns.RcodeServerFailure, err
+	}
+	if _, err := state.W.Write(buf); err != nil {
+		return dns.RcodeServerFailure, err
+	}
+	return rcode, nil
+}
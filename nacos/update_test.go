@@ -0,0 +1,228 @@
+/*
+ * Copyright 1999-2018 Alibaba Group Holding Ltd.
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *      http://www.apache.org/licenses/LICENSE-2.0
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package nacos
+
+import (
+	"context"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/coredns/coredns/plugin/test"
+	"github.com/miekg/dns"
+)
+
+const updateSecret = "c2VjcmV0LXNlY3JldC1zZWNyZXQ="
+
+// wireWriter keeps the responses written as wire format, signed update
+// responses are never written as messages.
+type wireWriter struct {
+	test.ResponseWriter
+	buf []byte
+}
+
+func (w *wireWriter) Write(buf []byte) (int, error) {
+	w.buf = buf
+	return len(buf), nil
+}
+
+func (w *wireWriter) WriteMsg(m *dns.Msg) error {
+	buf, err := m.Pack()
+	w.buf = buf
+	return err
+}
+
+// signedUpdate returns m as the plugin receives it after signing it with key.
+func signedUpdate(t *testing.T, m *dns.Msg, key, secret string) *dns.Msg {
+	m.SetTsig(key, dns.HmacSHA256, 300, time.Now().Unix())
+	buf, _, err := dns.TsigGenerate(m, secret, "", false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	r := new(dns.Msg)
+	if err := r.Unpack(buf); err != nil {
+		t.Fatal(err)
+	}
+	return r
+}
+
+func TestNacos_ServeUpdate(t *testing.T) {
+	var lock sync.Mutex
+	var calls []string
+	failIP := ""
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		lock.Lock()
+		defer lock.Unlock()
+
+		q := req.URL.Query()
+		if req.URL.Path == "/nacos/v1/ns/instance/list" {
+			w.Write([]byte(`{"hosts":[{"ip":"10.0.0.1","port":8080},{"ip":"10.0.0.2","port":8080},{"ip":"fd00::1","port":8080}]}`))
+			return
+		}
+		calls = append(calls, req.Method+" "+q.Get("serviceName")+" "+q.Get("ip")+":"+q.Get("port")+" "+q.Get("ephemeral"))
+		if q.Get("ip") == failIP {
+			http.Error(w, "failed", http.StatusInternalServerError)
+			return
+		}
+		w.Write([]byte("ok"))
+	}))
+	defer server.Close()
+
+	dir, err := ioutil.TempDir("", "nacos-update")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	host := strings.TrimPrefix(server.URL, "http://")
+	port, _ := strconv.Atoi(strings.Split(host, ":")[1])
+	client := &NacosClient{domainMap: NewConcurrentMap(), indexMap: NewConcurrentMap(), cachePath: dir, serverPort: port}
+	client.SetServers([]string{strings.Split(host, ":")[0]})
+
+	key, _ := NewTsigKey("hmac-sha256", updateSecret)
+	vs := &Nacos{NacosClientImpl: client, DNSCache: NewConcurrentMap(), Zones: []string{"svc.example."},
+		TsigKeys: map[string]TsigKey{"update.": key},
+		Mapper:   NameMapper{NameRules: []NameRule{{Pattern: regexp.MustCompile(`^(.+)\.svc\.example$`), Service: "$1", Group: "DNS"}}}}
+
+	newUpdate := func(rrs ...string) *dns.Msg {
+		m := new(dns.Msg)
+		m.SetUpdate("svc.example.")
+		for _, s := range rrs {
+			rr, err := dns.NewRR(s)
+			if err != nil {
+				t.Fatal(err)
+			}
+			m.Ns = append(m.Ns, rr)
+		}
+		return m
+	}
+
+	serve := func(r *dns.Msg) (int, *dns.Msg) {
+		lock.Lock()
+		calls = nil
+		lock.Unlock()
+
+		w := &wireWriter{}
+		rcode, err := vs.ServeDNS(context.TODO(), w, r)
+		if err != nil {
+			t.Fatal(err)
+		}
+		resp := new(dns.Msg)
+		if err := resp.Unpack(w.buf); err != nil {
+			t.Fatal(err)
+		}
+		return rcode, resp
+	}
+
+	// unsigned and wrongly signed updates are refused
+	if rcode, _ := serve(newUpdate("web.svc.example. 0 IN A 10.0.0.1")); rcode != dns.RcodeRefused {
+		t.Fatalf("expected unsigned update to be refused, got %s", dns.RcodeToString[rcode])
+	}
+	if rcode, _ := serve(signedUpdate(t, newUpdate("web.svc.example. 0 IN A 10.0.0.1"), "other.", updateSecret)); rcode != dns.RcodeNotAuth {
+		t.Fatalf("expected update with unknown key to fail, got %s", dns.RcodeToString[rcode])
+	}
+	if rcode, _ := serve(signedUpdate(t, newUpdate("web.svc.example. 0 IN A 10.0.0.1"), "update.", "b3RoZXI=")); rcode != dns.RcodeNotAuth {
+		t.Fatalf("expected update with bad signature to fail, got %s", dns.RcodeToString[rcode])
+	}
+	if len(calls) > 0 {
+		t.Fatalf("unauthenticated updates reached nacos: %v", calls)
+	}
+
+	// adds register persistent instances, the port comes from the SRV record
+	client.domainMap.Set(GetCacheKey("DNS@@web", "10.0.0.9"), Domain{Name: "DNS@@web"})
+	r := signedUpdate(t, newUpdate("web.svc.example. 60 IN A 10.0.0.1", "web.svc.example. 60 IN SRV 0 5 8080 web.svc.example."), "update.", updateSecret)
+	rcode, resp := serve(r)
+	if rcode != dns.RcodeSuccess {
+		t.Fatalf("expected update to succeed, got %s", dns.RcodeToString[rcode])
+	}
+	if resp.IsTsig() == nil {
+		t.Fatal("expected the response to be signed")
+	}
+	if err := dns.TsigVerify(mustPack(t, resp), updateSecret, r.IsTsig().MAC, false); err != nil {
+		t.Fatalf("bad signature of the response: %v", err)
+	}
+	if len(calls) != 1 || calls[0] != "POST DNS@@web 10.0.0.1:8080 false" {
+		t.Fatalf("unexpected calls %v", calls)
+	}
+	if _, ok := client.domainMap.Get(GetCacheKey("DNS@@web", "10.0.0.9")); ok {
+		t.Fatal("expected the cache of the service to be invalidated")
+	}
+
+	// deleting an RRset deregisters the instances of its family
+	m := newUpdate()
+	m.RemoveRRset([]dns.RR{&dns.A{Hdr: dns.RR_Header{Name: "web.svc.example.", Rrtype: dns.TypeA}}})
+	if rcode, _ = serve(signedUpdate(t, m, "update.", updateSecret)); rcode != dns.RcodeSuccess {
+		t.Fatalf("expected delete to succeed, got %s", dns.RcodeToString[rcode])
+	}
+	sort.Strings(calls)
+	if len(calls) != 2 || calls[0] != "DELETE DNS@@web 10.0.0.1:8080 false" || calls[1] != "DELETE DNS@@web 10.0.0.2:8080 false" {
+		t.Fatalf("unexpected calls %v", calls)
+	}
+
+	// a failing update restores the instances changed before it
+	m = newUpdate("web.svc.example. 60 IN A 10.0.0.3")
+	m.Remove([]dns.RR{mustRR(t, "web.svc.example. 60 IN A 10.0.0.1")})
+	m.Insert([]dns.RR{mustRR(t, "web.svc.example. 60 IN A 10.0.0.4")})
+	lock.Lock()
+	failIP = "10.0.0.4"
+	lock.Unlock()
+	if rcode, _ = serve(signedUpdate(t, m, "update.", updateSecret)); rcode != dns.RcodeServerFailure {
+		t.Fatalf("expected SERVFAIL, got %s", dns.RcodeToString[rcode])
+	}
+	expected := []string{"POST DNS@@web 10.0.0.3:0 false", "DELETE DNS@@web 10.0.0.1:8080 false", "POST DNS@@web 10.0.0.4:0 false",
+		"POST DNS@@web 10.0.0.1:8080 false", "DELETE DNS@@web 10.0.0.3:0 false"}
+	if strings.Join(calls, ",") != strings.Join(expected, ",") {
+		t.Fatalf("expected calls %v, got %v", expected, calls)
+	}
+
+	// names outside the zone and prerequisites are rejected
+	if rcode, _ = serve(signedUpdate(t, newUpdate("web.example.org. 60 IN A 10.0.0.1"), "update.", updateSecret)); rcode != dns.RcodeNotZone {
+		t.Fatalf("expected NOTZONE, got %s", dns.RcodeToString[rcode])
+	}
+	m = newUpdate("web.svc.example. 60 IN A 10.0.0.1")
+	m.NameUsed([]dns.RR{&dns.A{Hdr: dns.RR_Header{Name: "web.svc.example."}}})
+	if rcode, _ = serve(signedUpdate(t, m, "update.", updateSecret)); rcode != dns.RcodeNotImplemented {
+		t.Fatalf("expected NOTIMP, got %s", dns.RcodeToString[rcode])
+	}
+	if rcode, _ = serve(signedUpdate(t, newUpdate("web.svc.example. 60 IN SRV 0 5 8080 web.svc.example."), "update.", updateSecret)); rcode != dns.RcodeFormatError {
+		t.Fatalf("expected FORMERR for an SRV record without address, got %s", dns.RcodeToString[rcode])
+	}
+	if len(calls) > 0 {
+		t.Fatalf("rejected updates reached nacos: %v", calls)
+	}
+}
+
+func mustRR(t *testing.T, s string) dns.RR {
+	rr, err := dns.NewRR(s)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return rr
+}
+
+func mustPack(t *testing.T, m *dns.Msg) []byte {
+	buf, err := m.Pack()
+	if err != nil {
+		t.Fatal(err)
+	}
+	return buf
+}
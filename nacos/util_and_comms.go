@@ -16,28 +16,38 @@ package nacos
 import (
 	"bytes"
 	"compress/gzip"
+	"io"
 	"io/ioutil"
 	"net"
-	"time"
+	"strconv"
+
+	"github.com/cihub/seelog"
 )
 
 var (
 	DefaultCacheMillis = int64(5000)
 	Version            = "Nacos-DNS:v1.0.1"
-	CachePath          string
-	LogPath            string
+	DefaultCachePath   string
+	DefaultLogPath     string
 	SEPERATOR          = "@@"
 	GZIP_MAGIC         = []byte("\x1F\x8B")
 	EnableReceivePush  = true
-	UDP_Port           = -1
 	SERVER_PORT        = "8848"
+	// a push is a single UDP packet, anything inflating to more is a gzip bomb
+	MaxDecompressedSize = int64(1 << 20)
 )
 
 func CurrentMillis() int64 {
-	return time.Now().UnixNano() / 1e6
+	return millis(SystemClock.Now())
 }
 
+// TryDecompressData returns data, decompressed if it is gzipped. It logs to NacosClientLogger.
 func TryDecompressData(data []byte) string {
+	return tryDecompressData(data, NacosClientLogger)
+}
+
+// tryDecompressData is TryDecompressData logging to logger.
+func tryDecompressData(data []byte, logger seelog.LoggerInterface) string {
 
 	if !IsGzipFile(data) {
 		return string(data)
@@ -46,15 +56,20 @@ func TryDecompressData(data []byte) string {
 	reader, err := gzip.NewReader(bytes.NewReader(data))
 
 	if err != nil {
-		NacosClientLogger.Warn("failed to decompress gzip data", err)
+		logger.Warn("failed to decompress gzip data", err)
 		return ""
 	}
 
 	defer reader.Close()
-	bs, err1 := ioutil.ReadAll(reader)
+	bs, err1 := ioutil.ReadAll(io.LimitReader(reader, MaxDecompressedSize+1))
 
 	if err1 != nil {
-		NacosClientLogger.Warn("failed to decompress gzip data", err1)
+		logger.Warn("failed to decompress gzip data", err1)
+		return ""
+	}
+
+	if int64(len(bs)) > MaxDecompressedSize {
+		logger.Warn("gzip data inflates to more than " + strconv.FormatInt(MaxDecompressedSize, 10) + " bytes, ignore it")
 		return ""
 	}
 
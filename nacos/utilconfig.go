@@ -24,8 +24,7 @@ import (
 	"errors"
 )
 
-var DNSDomains = make(map[string]string)
-var DNSTTL uint32 = 1
+var DefaultDNSTTL uint32 = 1
 
 func Exist(path string) bool {
 	_, err := os.Stat(path)